@@ -12,5 +12,6 @@ require (
 	github.com/jinzhu/now v1.1.1
 	github.com/pkg/errors v0.8.1
 	github.com/skratchdot/open-golang v0.0.0-20190402232053-79abb63cd66e
+	golang.org/x/crypto v0.0.0-20190426145343-a29dc8fdc734
 	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
 )
@@ -14,7 +14,8 @@ const (
 )
 
 var (
-	dbDir = flag.String("db.dir", "", "Path to the local database directory")
+	dbDir      = flag.String("db.dir", "", "Path to the local database directory")
+	listenAddr = flag.String("listen.addr", "127.0.0.1:0", "Address for the local asset and api server")
 )
 
 func main() {
@@ -32,12 +33,27 @@ func main() {
 	log.Printf("using database dir at %s", *dbDir)
 
 	db, err := badger.Open(badger.DefaultOptions(*dbDir))
+	if harvester.DatabaseLocked(err) && flag.NArg() > 0 {
+		// The app holds the database, run the command through its api
+		if err := harvester.RunRemoteCommand(flag.Args(), os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
 	if err != nil {
 		log.Fatal("Unable to open database", err)
 	}
 	defer db.Close()
 
-	h, err := harvester.NewHarvester(db)
+	// Any arguments are treated as a headless command instead of opening the app
+	if flag.NArg() > 0 {
+		if err := harvester.RunCommand(db, flag.Args(), os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	h, err := harvester.NewHarvester(db, *listenAddr)
 	if err != nil {
 		log.Fatalln("Unable to get new harvester", err)
 	}
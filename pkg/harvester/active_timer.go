@@ -0,0 +1,244 @@
+package harvester
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+const activeTimerPrefix = "active."
+
+const (
+	recoverResume  = "resume"
+	recoverStop    = "stop"
+	recoverDiscard = "discard"
+)
+
+// activeTimer is the persisted state of a running timer. It is written when a
+// timer starts and refreshed on every heartbeat so the time can be recovered
+// if harvester exits without stopping it.
+type activeTimer struct {
+	Key           string    `json:"key"`
+	StartedAt     time.Time `json:"startedAt"`
+	LastHeartbeat time.Time `json:"lastHeartbeat"`
+	EntryID       int64     `json:"entryId,omitempty"`
+
+	// Paused timers have no start time, only the pending time worked
+	Paused  bool           `json:"paused,omitempty"`
+	Pending []TimeInterval `json:"pending,omitempty"`
+	Notes   string         `json:"notes,omitempty"`
+
+	// Detached timers were started from the command line, nothing keeps
+	// their heartbeat going so they are taken as running until stopped
+	Detached bool `json:"detached,omitempty"`
+}
+
+// UnmarshalJSON reads the entry id of records saved when harvest was the only
+// backend as well.
+func (a *activeTimer) UnmarshalJSON(data []byte) error {
+	type record activeTimer
+	var r struct {
+		record
+		HarvestEntryID int64 `json:"harvestEntryId"`
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+
+	*a = activeTimer(r.record)
+	if a.EntryID == 0 {
+		a.EntryID = r.HarvestEntryID
+	}
+	return nil
+}
+
+func newActiveTimer(t *TaskTimer) activeTimer {
+	active := activeTimer{
+		Key:           t.Key,
+		LastHeartbeat: time.Now().UTC(),
+		Paused:        t.Paused,
+		Pending:       t.Pending,
+		Notes:         t.Notes,
+	}
+	if t.StartedAt != nil {
+		active.StartedAt = *t.StartedAt
+	}
+	if t.Entry != nil {
+		active.EntryID = t.Entry.ID
+	}
+	return active
+}
+
+func (a activeTimer) taskTimer() *TaskTimer {
+	if a.Paused {
+		return &TaskTimer{
+			Key:     a.Key,
+			Paused:  true,
+			Pending: a.Pending,
+			Notes:   a.Notes,
+		}
+	}
+
+	startedAt := a.StartedAt
+	return &TaskTimer{
+		Key:       a.Key,
+		StartedAt: &startedAt,
+		Running:   true,
+		Pending:   a.Pending,
+		Notes:     a.Notes,
+	}
+}
+
+// saveActiveTimer records a running timer so it survives the process that
+// started it. Only one process can open the database at a time, so the app
+// picks up timers started from the command line the next time it starts, and
+// the command line goes through the api of the app while it runs.
+func (h *harvester) saveActiveTimer(t *TaskTimer) error {
+	active := newActiveTimer(t)
+	active.Detached = h.app == nil
+
+	data, err := json.Marshal(active)
+	if err != nil {
+		return err
+	}
+
+	return h.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(activeTimerPrefix+t.Key), data)
+	})
+}
+
+func (h *harvester) deleteActiveTimer(key string) error {
+	return h.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(activeTimerPrefix + key))
+	})
+}
+
+func getActiveTimers(db *badger.DB) ([]activeTimer, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(activeTimerPrefix)
+
+	var timers []activeTimer
+	err := db.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			var timer activeTimer
+			err := iter.Item().Value(func(v []byte) error {
+				return json.Unmarshal(v, &timer)
+			})
+			if err != nil {
+				return err
+			}
+
+			timers = append(timers, timer)
+		}
+		return nil
+	})
+
+	return timers, err
+}
+
+// heartbeat marks all running timers as still alive. Timers waiting to be
+// recovered keep their last heartbeat until the user decides what to do.
+// Pending time of days that are over is stored.
+func (h *harvester) heartbeat() error {
+TIMER:
+	for _, timer := range h.Timers {
+		if !timer.Running && !timer.Paused {
+			continue
+		}
+		if err := h.commitPastDays(timer); err != nil {
+			return err
+		}
+		if !timer.Running {
+			continue
+		}
+		for _, active := range h.recovery {
+			if active.Key == timer.Key {
+				continue TIMER
+			}
+		}
+
+		if err := h.saveActiveTimer(timer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type recoverRequest struct {
+	Key    string `json:"key"`
+	Action string `json:"action"`
+}
+
+func (h *harvester) registerRecoveryHandlers() {
+	h.registerRPC("timer.recover", func(payload json.RawMessage) (interface{}, error) {
+		var req recoverRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return nil, err
+		}
+
+		if err := h.recoverTimer(req.Key, req.Action); err != nil {
+			return nil, err
+		}
+
+		if len(h.recovery) > 0 {
+			return nil, h.renderRecovery()
+		}
+		return nil, h.renderMainWindow()
+	})
+}
+
+// recoverTimer resolves a timer left running by a previous run. The timer can
+// be resumed as if harvester never stopped, stopped at the last heartbeat
+// before harvester went away, or discarded without tracking any time.
+func (h *harvester) recoverTimer(key, action string) error {
+	if action != recoverResume && action != recoverStop && action != recoverDiscard {
+		return &rpcError{Code: rpcErrBadRequest, Message: fmt.Sprintf("unknown recover action %s", action)}
+	}
+
+	var active *activeTimer
+	for i, a := range h.recovery {
+		if a.Key == key {
+			found := a
+			active = &found
+			h.recovery = append(h.recovery[:i], h.recovery[i+1:]...)
+			break
+		}
+	}
+	if active == nil {
+		return &rpcError{Code: rpcErrBadRequest, Message: "no timer to recover for " + key}
+	}
+
+	timer, err := h.Timers.GetByKey(key)
+	if err != nil {
+		return err
+	}
+
+	if action == recoverResume {
+		return nil
+	}
+
+	// The backend timer kept running while harvester was gone, take the time
+	// that is not kept locally off its entry. The entry may not have been
+	// picked up again yet.
+	keptUntil := active.LastHeartbeat
+	if action == recoverDiscard {
+		keptUntil = active.StartedAt
+	}
+	entryID := active.EntryID
+	if timer.Entry != nil {
+		entryID = timer.Entry.ID
+	}
+	if entryID != 0 && h.backend != nil {
+		if _, err := h.trimRemoteTime(entryID, time.Since(keptUntil)); err != nil {
+			log.Printf("unable to stop %s entry %d: %s", h.backend.Name(), entryID, err)
+		}
+		timer.Entry = nil
+	}
+
+	return h.stopTimerAt(timer, active.LastHeartbeat, action == recoverStop)
+}
@@ -0,0 +1,276 @@
+package harvester
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	apiPrefix = "/api/v1/"
+
+	// apiTokenFile holds the bearer token api requests need, in the
+	// harvester directory
+	apiTokenFile = "api.token"
+
+	// apiAddrFile holds the address the api of the running app listens on
+	apiAddrFile = "api.addr"
+)
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// apiHandler serves the local REST api used by scripts and editor plugins.
+//
+//	GET  /api/v1/timers
+//	POST /api/v1/timers/{key}/start
+//	POST /api/v1/timers/{key}/pause
+//	POST /api/v1/timers/{key}/stop
+//	GET  /api/v1/timesheet?start=2006-01-02&end=2006-01-02[&group=project|client|epic]
+//	GET  /api/v1/timeline?day=2006-01-02
+//	GET  /api/v1/settings
+//	PUT  /api/v1/settings
+//
+// Requests need the token of the install as a bearer token.
+func (h *harvester) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiPrefix+"timers", h.apiTimers)
+	mux.HandleFunc(apiPrefix+"timers/", h.apiTimerAction)
+	mux.HandleFunc(apiPrefix+"timesheet", h.apiTimesheet)
+	mux.HandleFunc(apiPrefix+"timeline", h.apiTimeline)
+	mux.HandleFunc(apiPrefix+"settings", h.apiSettings)
+	return h.apiGuard(mux)
+}
+
+// apiGuard lets through requests with the api token, one at a time. Browsers
+// add an Origin to requests from web pages, those are rejected outright.
+func (h *harvester) apiGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && origin != "http://"+h.listener.Addr().String() {
+			writeAPIError(w, http.StatusForbidden, "cross origin requests are not allowed")
+			return
+		}
+
+		auth := []byte(r.Header.Get("Authorization"))
+		if h.apiToken == "" || subtle.ConstantTimeCompare(auth, []byte("Bearer "+h.apiToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, http.StatusUnauthorized, "missing or invalid api token")
+			return
+		}
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readAPIToken reads the api token from tokenFile, creating a random one if
+// it does not exist yet.
+func readAPIToken(tokenFile string) (string, error) {
+	token, err := ioutil.ReadFile(tokenFile)
+	if err == nil {
+		return strings.TrimSpace(string(token)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(tokenFile), 0700); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(tokenFile, []byte(hex.EncodeToString(raw)), 0600); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func (h *harvester) apiTimers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	for _, t := range h.Timers {
+		t.Running = (t.StartedAt != nil)
+		t.Runtime = t.CurrentRuntime()
+	}
+
+	writeAPIResponse(w, http.StatusOK, h.Timers)
+}
+
+func (h *harvester) apiTimerAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	// Keys can not contain a slash so split off the action from the end
+	path := strings.TrimPrefix(r.URL.Path, apiPrefix+"timers/")
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		writeAPIError(w, http.StatusNotFound, "not found")
+		return
+	}
+	key, action := path[:i], path[i+1:]
+
+	// Keys without a timer yet are started like from the command line
+	timer, err := h.Timers.GetByKey(key)
+	if err == ErrTimerNotExists && action == "start" {
+		timer, err = &TaskTimer{Key: key}, nil
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	switch action {
+	case "start":
+		err = h.StartTimer(timer)
+	case "pause":
+		err = h.PauseTimer(timer)
+	case "stop":
+		err = h.StopTimer(timer)
+	default:
+		writeAPIError(w, http.StatusNotFound, "unknown action "+action)
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.sendTimers(false, false)
+
+	timer, _ = h.Timers.GetByKey(key)
+	writeAPIResponse(w, http.StatusOK, timer)
+}
+
+func (h *harvester) apiTimesheet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	start, err := parseAPITime(r.URL.Query().Get("start"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid start: "+err.Error())
+		return
+	}
+	end, err := parseAPITime(r.URL.Query().Get("end"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid end: "+err.Error())
+		return
+	}
+	if !end.After(start) {
+		writeAPIError(w, http.StatusBadRequest, "end must be after start")
+		return
+	}
+
+	timesheet, err := h.getTimeSheet(start.UTC(), end.UTC(), r.URL.Query().Get("group"))
+	if _, ok := err.(*rpcError); ok {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, timesheet)
+}
+
+func (h *harvester) apiTimeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	day, err := parseAPITime(r.URL.Query().Get("day"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid day: "+err.Error())
+		return
+	}
+
+	timeline, err := h.getDayTimeline(day.Local())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, timeline)
+}
+
+func (h *harvester) apiSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeAPIResponse(w, http.StatusOK, h.Settings.withoutSecrets())
+	case http.MethodPut:
+		var settings Settings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if settings.JiraWorklogsSince == nil {
+			settings.JiraWorklogsSince = h.Settings.JiraWorklogsSince
+		}
+
+		// Secrets are never returned so keep the current ones if none are
+		// sent, unless they would go to another site
+		current := []*SettingsData{&h.Settings.Jira, &h.Settings.Harvest}
+		for i, data := range []*SettingsData{&settings.Jira, &settings.Harvest} {
+			if data.URL != current[i].URL {
+				continue
+			}
+			if data.APIURL == "" {
+				data.APIURL = current[i].APIURL
+			}
+
+			currentSecrets := current[i].secrets()
+			for j, secret := range data.secrets() {
+				if *secret == "" {
+					*secret = *currentSecrets[j]
+				}
+			}
+		}
+
+		*h.Settings = settings
+		h.settingsChanged()
+
+		writeAPIResponse(w, http.StatusOK, h.Settings.withoutSecrets())
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// parseAPITime accepts either a full RFC3339 timestamp or a local date.
+func parseAPITime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIResponse(w, status, apiError{Error: message})
+}
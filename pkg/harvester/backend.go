@@ -0,0 +1,119 @@
+package harvester
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Backend is a time tracking service the tracked time is logged to. Projects
+// are matched to timers by their code, which is the key of the timer.
+type Backend interface {
+	// Name is shown to the user when referring to the backend
+	Name() string
+
+	// URL is the web page of the account, nil when there is none
+	URL() (*url.URL, error)
+
+	// Projects lists every project the user can log time to
+	Projects() (Projects, error)
+
+	// StartTimer starts a running entry for today and StopTimer stops it
+	StartTimer(projectID, taskID int64, notes string) (*Entry, error)
+	StopTimer(entryID int64) (*Entry, error)
+
+	// RunningEntries returns the entries with a running timer
+	RunningEntries() ([]*Entry, error)
+
+	// ListEntries returns every entry spent on or after the local day from
+	ListEntries(from time.Time) ([]*Entry, error)
+	CreateEntry(projectID, taskID int64, day time.Time, hours float64, notes string) (*Entry, error)
+
+	// UpdateEntry sets the hours of an entry, and the notes unless empty.
+	// UpdateNotes only sets the notes so it can be used on running entries.
+	UpdateEntry(entryID int64, hours float64, notes string) (*Entry, error)
+	UpdateNotes(entryID int64, notes string) (*Entry, error)
+}
+
+// timerRestarter is implemented by backends able to restart a stopped timer
+// on the same entry, otherwise a new timer is started.
+type timerRestarter interface {
+	RestartTimer(entryID int64) (*Entry, error)
+}
+
+// Project is a project of the backend and the tasks time can be logged to.
+type Project struct {
+	ID     int64         `json:"id"`
+	Code   string        `json:"code"`
+	Name   string        `json:"name"`
+	Client string        `json:"client"`
+	Tasks  []ProjectTask `json:"tasks"`
+}
+
+type ProjectTask struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type Projects []*Project
+
+func (p Projects) getByKey(key string) (*Project, error) {
+	for _, project := range p {
+		if project.Code == key {
+			return project, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no project with key %s found", key)
+}
+
+// Entry is time logged to a project of the backend. Day is the local day the
+// time was spent on.
+type Entry struct {
+	ID        int64     `json:"id"`
+	ProjectID int64     `json:"projectId"`
+	Code      string    `json:"code"`
+	TaskID    int64     `json:"taskId"`
+	Day       time.Time `json:"day"`
+	Hours     float64   `json:"hours"`
+	Notes     string    `json:"notes"`
+	Running   bool      `json:"running"`
+}
+
+// withCodes returns the projects with a code, timers are keyed by the code
+// so projects without one get no timer of their own.
+func (p Projects) withCodes() Projects {
+	projects := make(Projects, 0, len(p))
+	for _, project := range p {
+		if project.Code != "" {
+			projects = append(projects, project)
+		}
+	}
+	return projects
+}
+
+// getIncludedProjects returns the projects time can be logged to, leaving
+// out any projects excluded in the settings.
+func (h *harvester) getIncludedProjects() (Projects, error) {
+	projects, err := h.backend.Projects()
+	if err != nil {
+		return nil, err
+	}
+
+	included := make(Projects, 0, len(projects))
+	for _, project := range projects {
+		if !h.Settings.projectExcluded(project.ID) {
+			included = append(included, project)
+		}
+	}
+	return included, nil
+}
+
+// getProjects returns the included projects that get a timer.
+func (h *harvester) getProjects() (Projects, error) {
+	projects, err := h.getIncludedProjects()
+	if err != nil {
+		return nil, err
+	}
+	return projects.withCodes(), nil
+}
@@ -0,0 +1,294 @@
+package harvester
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// RunCommand runs a single headless command against the database without
+// starting electron. Supported commands are start, pause, stop, status and
+// timesheet.
+func RunCommand(db *badger.DB, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command given")
+	}
+
+	h, err := newHarvester(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "start":
+		return h.cliStart(args[1:], out)
+	case "pause":
+		return h.cliPause(args[1:], out)
+	case "stop":
+		return h.cliStop(args[1:], out)
+	case "status":
+		return h.cliStatus(out)
+	case "timesheet":
+		return h.cliTimesheet(args[1:], out)
+	default:
+		return fmt.Errorf("unknown command %s", args[0])
+	}
+}
+
+func (h *harvester) cliStart(args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: start KEY")
+	}
+	key := args[0]
+
+	// Pull in jira and harvest details so remote timers are started as well
+	if err := h.Refresh(); err != nil {
+		log.Println(err)
+	}
+
+	timer, err := h.Timers.GetByKey(key)
+	if err == ErrTimerNotExists {
+		timer = &TaskTimer{Key: key}
+	}
+
+	if err := h.StartTimer(timer); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "started %s\n", key)
+	return nil
+}
+
+func (h *harvester) cliPause(args []string, out io.Writer) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: pause [KEY]")
+	}
+
+	// Pull in harvest details so running remote timers are stopped as well
+	if err := h.Refresh(); err != nil {
+		log.Println(err)
+	}
+
+	for _, timer := range h.Timers {
+		if !timer.Running {
+			continue
+		}
+		if len(args) == 1 && timer.Key != args[0] {
+			continue
+		}
+
+		if err := h.PauseTimer(timer); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "paused %s after %s\n", timer.Key, timer.CurrentRuntime())
+	}
+
+	return nil
+}
+
+func (h *harvester) cliStop(args []string, out io.Writer) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: stop [KEY]")
+	}
+
+	// Pull in harvest details so running remote timers are stopped as well
+	if err := h.Refresh(); err != nil {
+		log.Println(err)
+	}
+
+	for _, timer := range h.Timers {
+		if !timer.Running && !timer.Paused {
+			continue
+		}
+		if len(args) == 1 && timer.Key != args[0] {
+			continue
+		}
+
+		runtime := timer.CurrentRuntime()
+		if err := h.StopTimer(timer); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "stopped %s after %s\n", timer.Key, runtime)
+	}
+
+	return nil
+}
+
+func (h *harvester) cliStatus(out io.Writer) error {
+	return printStatus(out, h.Timers)
+}
+
+// printStatus lists the running and paused timers.
+func printStatus(out io.Writer, timers TaskTimers) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	running := 0
+	for _, timer := range timers {
+		switch {
+		case timer.Running:
+			fmt.Fprintf(w, "%s\t%s\tsince %s\n", timer.Key, timer.CurrentRuntime(), timer.StartedAt.Local().Format("15:04"))
+		case timer.Paused:
+			fmt.Fprintf(w, "%s\t%s\tpaused\n", timer.Key, timer.CurrentRuntime())
+		default:
+			continue
+		}
+		running++
+	}
+
+	if running == 0 {
+		fmt.Fprintln(w, "no timers running")
+	}
+	return nil
+}
+
+func (h *harvester) cliTimesheet(args []string, out io.Writer) error {
+	opts, err := parseTimesheetArgs(args, out)
+	if err != nil {
+		return err
+	}
+
+	// Groups and reports need the projects, issues and tasks of the keys
+	if opts.group != "" || opts.export != "" {
+		if err := h.Refresh(); err != nil {
+			log.Println(err)
+		}
+	}
+
+	timesheet, err := h.getTimeSheet(opts.start.UTC(), opts.end.UTC(), opts.group)
+	if err != nil {
+		return err
+	}
+
+	return opts.write(out, timesheet, func(w io.Writer) error {
+		return h.exportTimesheet(w, timesheet, opts.export)
+	})
+}
+
+// timesheetOptions are the flags of the timesheet command.
+type timesheetOptions struct {
+	view   string
+	start  time.Time
+	end    time.Time
+	group  string
+	export string
+	output string
+}
+
+func parseTimesheetArgs(args []string, out io.Writer) (*timesheetOptions, error) {
+	flags := flag.NewFlagSet("timesheet", flag.ContinueOnError)
+	flags.SetOutput(out)
+	week := flags.Bool("week", false, "Show the current week instead of today")
+	month := flags.Bool("month", false, "Show the current month instead of today")
+	from := flags.String("from", "", "First day of a custom range, as 2006-01-02")
+	to := flags.String("to", "", "Last day of a custom range, as 2006-01-02, defaults to today")
+	offset := flags.Int("offset", 0, "Number of days, weeks, months or ranges to move back from the current one")
+	group := flags.String("group", "", "Group the time by project, client or epic instead of by key")
+	export := flags.String("export", "", "Export the timesheet as csv, json, an html or a pdf report instead of printing it")
+	output := flags.String("output", "", "File to export to, defaults to standard output")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	req := timesheetRequest{
+		View:    "day",
+		Start:   time.Now(),
+		Move:    "=",
+		GroupBy: *group,
+	}
+	switch {
+	case *from != "":
+		start, err := time.ParseInLocation("2006-01-02", *from, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from: %s", err)
+		}
+		end := time.Now()
+		if *to != "" {
+			if end, err = time.ParseInLocation("2006-01-02", *to, time.Local); err != nil {
+				return nil, fmt.Errorf("invalid to: %s", err)
+			}
+		}
+		req.View, req.Start, req.End, req.Move = "range", start, end, "."
+	case *month:
+		req.View = "month"
+	case *week:
+		req.View = "week"
+	}
+
+	start, end, err := req.period()
+	for i := 0; i < *offset && err == nil; i++ {
+		req.Start, req.Move = start, "-"
+		start, end, err = req.period()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &timesheetOptions{
+		view:   req.View,
+		start:  start,
+		end:    end,
+		group:  *group,
+		export: *export,
+		output: *output,
+	}, nil
+}
+
+// write exports the timesheet with export when asked to, otherwise it prints
+// the timesheet as a table.
+func (opts *timesheetOptions) write(out io.Writer, timesheet *TimeSheet, export func(w io.Writer) error) error {
+	if opts.export != "" {
+		if opts.output != "" {
+			return writeExportFile(opts.output, opts.export, export)
+		}
+		return export(out)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', tabwriter.AlignRight)
+	defer w.Flush()
+
+	// Single days only show the totals
+	days := len(timesheet.Days) > 1
+	dayFormat := "Mon"
+	if opts.view != "week" {
+		dayFormat = "Jan 2"
+	}
+
+	header := []string{"Key"}
+	if timesheet.GroupBy != groupByKey {
+		header[0] = strings.Title(timesheet.GroupBy)
+	}
+	if days {
+		for _, day := range timesheet.Days {
+			header = append(header, day.Format(dayFormat))
+		}
+	}
+	header = append(header, "Total")
+	fmt.Fprintln(w, strings.Join(header, "\t")+"\t")
+
+	for _, task := range timesheet.Tasks {
+		fmt.Fprint(w, task.Key+"\t")
+		if days {
+			for _, d := range task.Durations {
+				fmt.Fprintf(w, "%.2f\t", d)
+			}
+		}
+		fmt.Fprintf(w, "%.2f\t\n", task.TotalTime)
+	}
+
+	fmt.Fprint(w, "Total\t")
+	if days {
+		for _, d := range timesheet.DaysTotal {
+			fmt.Fprintf(w, "%.2f\t", d)
+		}
+	}
+	fmt.Fprintf(w, "%.2f\t\n", timesheet.Total)
+
+	return nil
+}
@@ -0,0 +1,224 @@
+package harvester
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// errAppRunning is returned for commands that need the database while the
+// app holds it.
+var errAppRunning = errors.New("harvester is running and holds the database, close it or use the app")
+
+// DatabaseLocked reports whether opening the database failed because another
+// harvester process, usually the app, has it open.
+func DatabaseLocked(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Another process is using this Badger database")
+}
+
+// RunRemoteCommand runs a headless command through the local api of the
+// running app. Badger only lets one process open the database, so while the
+// app runs the command line controls its timers through the app instead.
+func RunRemoteCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command given")
+	}
+
+	c, err := newRemoteClient()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "start":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: start KEY")
+		}
+		if err := c.timerAction(args[1], "start"); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "started %s\n", args[1])
+		return nil
+	case "pause":
+		return c.cliPause(args[1:], out)
+	case "stop":
+		return c.cliStop(args[1:], out)
+	case "status":
+		timers, err := c.timers()
+		if err != nil {
+			return err
+		}
+		return printStatus(out, timers)
+	case "timesheet":
+		return c.cliTimesheet(args[1:], out)
+	default:
+		return fmt.Errorf("unknown command %s", args[0])
+	}
+}
+
+// remoteClient calls the api of the running app with the token of the
+// install.
+type remoteClient struct {
+	base  string
+	token string
+}
+
+func newRemoteClient() (*remoteClient, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	harvesterDir := home + "/.harvester"
+
+	addr, err := ioutil.ReadFile(harvesterDir + "/" + apiAddrFile)
+	if os.IsNotExist(err) {
+		return nil, errAppRunning
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := ioutil.ReadFile(harvesterDir + "/" + apiTokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteClient{
+		base:  "http://" + strings.TrimSpace(string(addr)) + apiPrefix,
+		token: strings.TrimSpace(string(token)),
+	}, nil
+}
+
+// do sends the request decoding the response into v unless it is nil.
+func (c *remoteClient) do(method, path string, v interface{}) error {
+	req, err := http.NewRequest(method, c.base+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The app stopped without removing its address
+		return errAppRunning
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr apiError
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil || apiErr.Error == "" {
+			return fmt.Errorf("harvester app: %s", resp.Status)
+		}
+		return fmt.Errorf("harvester app: %s", apiErr.Error)
+	}
+
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *remoteClient) timers() (TaskTimers, error) {
+	var timers TaskTimers
+	return timers, c.do(http.MethodGet, "timers", &timers)
+}
+
+func (c *remoteClient) timerAction(key, action string) error {
+	return c.do(http.MethodPost, "timers/"+url.PathEscape(key)+"/"+action, nil)
+}
+
+func (c *remoteClient) cliPause(args []string, out io.Writer) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: pause [KEY]")
+	}
+
+	timers, err := c.timers()
+	if err != nil {
+		return err
+	}
+
+	for _, timer := range timers {
+		if !timer.Running {
+			continue
+		}
+		if len(args) == 1 && timer.Key != args[0] {
+			continue
+		}
+
+		if err := c.timerAction(timer.Key, "pause"); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "paused %s after %s\n", timer.Key, timer.CurrentRuntime())
+	}
+
+	return nil
+}
+
+func (c *remoteClient) cliStop(args []string, out io.Writer) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: stop [KEY]")
+	}
+
+	timers, err := c.timers()
+	if err != nil {
+		return err
+	}
+
+	for _, timer := range timers {
+		if !timer.Running && !timer.Paused {
+			continue
+		}
+		if len(args) == 1 && timer.Key != args[0] {
+			continue
+		}
+
+		if err := c.timerAction(timer.Key, "stop"); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "stopped %s after %s\n", timer.Key, timer.CurrentRuntime())
+	}
+
+	return nil
+}
+
+// cliTimesheet prints the timesheet of the app, exports to csv and json are
+// written from it as well. Reports need the projects and issues of the
+// database.
+func (c *remoteClient) cliTimesheet(args []string, out io.Writer) error {
+	opts, err := parseTimesheetArgs(args, out)
+	if err != nil {
+		return err
+	}
+
+	switch opts.export {
+	case "", exportCSV, exportJSON:
+	default:
+		if err := checkExportFormat(opts.export); err != nil {
+			return err
+		}
+		return errAppRunning
+	}
+
+	query := url.Values{
+		"start": {opts.start.Format(time.RFC3339)},
+		"end":   {opts.end.Format(time.RFC3339)},
+		"group": {opts.group},
+	}
+
+	var timesheet TimeSheet
+	if err := c.do(http.MethodGet, "timesheet?"+query.Encode(), &timesheet); err != nil {
+		return err
+	}
+
+	return opts.write(out, &timesheet, func(w io.Writer) error {
+		return exportTimesheetData(w, &timesheet, opts.export)
+	})
+}
@@ -0,0 +1,81 @@
+package harvester
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+// newTestHarvester returns a harvester without a window on a new database,
+// with its home in a temporary directory.
+func newTestHarvester(t *testing.T) (*harvester, string) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", home+"/.config")
+
+	dir := t.TempDir()
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil)); !DatabaseLocked(err) {
+		t.Fatalf("opening the database twice returned %v", err)
+	}
+
+	h, err := newHarvester(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, home
+}
+
+func TestRunRemoteCommand(t *testing.T) {
+	h, home := newTestHarvester(t)
+	h.apiToken = "token"
+
+	srv := httptest.NewServer(h.apiHandler())
+	defer srv.Close()
+
+	if err := os.MkdirAll(home+"/.harvester", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(home+"/.harvester/"+apiAddrFile, []byte(srv.Listener.Addr().String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(home+"/.harvester/"+apiTokenFile, []byte(h.apiToken), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) string {
+		var out bytes.Buffer
+		if err := RunRemoteCommand(args, &out); err != nil {
+			t.Fatalf("%s: %s", args, err)
+		}
+		return out.String()
+	}
+
+	if out := run("start", "ABC-1"); out != "started ABC-1\n" {
+		t.Errorf("start printed %q", out)
+	}
+	if out := run("status"); !strings.HasPrefix(out, "ABC-1") {
+		t.Errorf("status printed %q", out)
+	}
+	if out := run("stop"); !strings.HasPrefix(out, "stopped ABC-1") {
+		t.Errorf("stop printed %q", out)
+	}
+	if out := run("status"); out != "no timers running\n" {
+		t.Errorf("status after stop printed %q", out)
+	}
+
+	var out bytes.Buffer
+	if err := RunRemoteCommand([]string{"timesheet", "--export", "pdf"}, &out); err != errAppRunning {
+		t.Errorf("pdf export through the app returned %v", err)
+	}
+}
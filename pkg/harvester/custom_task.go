@@ -0,0 +1,197 @@
+package harvester
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+)
+
+const customTaskPrefix = "task."
+
+// CustomTask is a task tracked locally without an issue or a project code,
+// like meetings or code reviews. Time can still be logged to a backend
+// project when both ProjectID and TaskID are set.
+type CustomTask struct {
+	Key       string `json:"key"`
+	Title     string `json:"title"`
+	ProjectID int64  `json:"projectId,omitempty"`
+	TaskID    int64  `json:"taskId,omitempty"`
+	Color     string `json:"color,omitempty"`
+}
+
+type CustomTasks []*CustomTask
+
+func (t *CustomTask) validate() error {
+	if t.Key == "" || t.Title == "" {
+		return &rpcError{Code: rpcErrBadRequest, Message: "custom tasks need a key and a title"}
+	}
+
+	// Colons qualify the keys of trackers and slashes split api paths
+	if strings.ContainsAny(t.Key, ":/ \t") {
+		return &rpcError{Code: rpcErrBadRequest, Message: fmt.Sprintf("invalid key %s, keys can not contain spaces, colons or slashes", t.Key)}
+	}
+
+	if (t.ProjectID == 0) != (t.TaskID == 0) {
+		return &rpcError{Code: rpcErrBadRequest, Message: "choose both a project and a task to log time to"}
+	}
+	return nil
+}
+
+// forEntry returns the custom task logging time to the project and task of
+// the entry, nil when there is none.
+func (tasks CustomTasks) forEntry(entry *Entry) *CustomTask {
+	for _, task := range tasks {
+		if task.ProjectID != 0 && task.ProjectID == entry.ProjectID && task.TaskID == entry.TaskID {
+			return task
+		}
+	}
+	return nil
+}
+
+func (tasks CustomTasks) getByKey(key string) *CustomTask {
+	for _, task := range tasks {
+		if task.Key == key {
+			return task
+		}
+	}
+	return nil
+}
+
+// syncTargets returns the projects and entries to sync with the custom tasks
+// added. Each task gets a copy of its project under its own key, and entries
+// logged to the task are keyed by it.
+func (tasks CustomTasks) syncTargets(projects Projects, entries []*Entry) (Projects, []*Entry) {
+	targets := projects.withCodes()
+	for _, task := range tasks {
+		for _, project := range projects {
+			if project.ID != task.ProjectID {
+				continue
+			}
+
+			target := *project
+			target.Code = task.Key
+			targets = append(targets, &target)
+		}
+	}
+
+	keyed := make([]*Entry, 0, len(entries))
+	for _, entry := range entries {
+		if task := tasks.forEntry(entry); task != nil {
+			custom := *entry
+			custom.Code = task.Key
+			entry = &custom
+		}
+		keyed = append(keyed, entry)
+	}
+	return targets, keyed
+}
+
+func getCustomTasks(db *badger.DB) (CustomTasks, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(customTaskPrefix)
+
+	tasks := make(CustomTasks, 0)
+	err := db.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			var task CustomTask
+			err := iter.Item().Value(func(v []byte) error {
+				return json.Unmarshal(v, &task)
+			})
+			if err != nil {
+				return err
+			}
+
+			tasks = append(tasks, &task)
+		}
+		return nil
+	})
+
+	return tasks, err
+}
+
+func (t *CustomTask) Save(db *badger.DB) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(customTaskPrefix+t.Key), data)
+	})
+}
+
+func deleteCustomTask(db *badger.DB, key string) error {
+	return db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(customTaskPrefix + key))
+	})
+}
+
+type customTaskData struct {
+	Tasks    CustomTasks `json:"tasks"`
+	Projects Projects    `json:"projects"`
+}
+
+func (h *harvester) registerCustomTaskHandlers() {
+	h.registerRPC("task.list", func(json.RawMessage) (interface{}, error) {
+		tasks, err := getCustomTasks(h.db)
+		if err != nil {
+			return nil, err
+		}
+
+		data := customTaskData{
+			Tasks:    tasks,
+			Projects: make(Projects, 0),
+		}
+		if h.backend == nil {
+			return data, nil
+		}
+
+		// Any included project can be logged to, even without a code
+		data.Projects, err = h.getIncludedProjects()
+		if err != nil {
+			return nil, err
+		}
+		return data, nil
+	})
+
+	h.registerRPC("task.save", func(payload json.RawMessage) (interface{}, error) {
+		var task CustomTask
+		if err := decodePayload(payload, &task); err != nil {
+			return nil, err
+		}
+		if err := task.validate(); err != nil {
+			return nil, err
+		}
+
+		// Keys of issues and projects are taken by their own timers
+		timer, err := h.Timers.GetByKey(task.Key)
+		if err == nil && timer.Custom == nil && (timer.Issue != nil || timer.Project != nil) {
+			return nil, &rpcError{Code: rpcErrBadRequest, Message: fmt.Sprintf("%s is already tracked", task.Key)}
+		}
+
+		if err := task.Save(h.db); err != nil {
+			return nil, err
+		}
+		return nil, h.Refresh()
+	})
+
+	h.registerRPC("task.delete", func(payload json.RawMessage) (interface{}, error) {
+		var req timerRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return nil, err
+		}
+
+		if timer, err := h.Timers.GetByKey(req.Key); err == nil {
+			if timer.Running || timer.Paused {
+				return nil, &rpcError{Code: rpcErrBadRequest, Message: fmt.Sprintf("stop the timer for %s before deleting it", req.Key)}
+			}
+			h.removeTask(req.Key)
+		}
+
+		return nil, deleteCustomTask(h.db, req.Key)
+	})
+}
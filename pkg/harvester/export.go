@@ -0,0 +1,283 @@
+package harvester
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Timesheets export to these formats. The window prints pdfs from the html
+// report with electron, the command line writes them itself.
+const (
+	exportCSV  = "csv"
+	exportJSON = "json"
+	exportHTML = "html"
+	exportPDF  = "pdf"
+)
+
+// exportRequest exports the requested timesheet, the window saves the
+// content to the file chosen by the user.
+type exportRequest struct {
+	timesheetRequest
+	Format string `json:"format"`
+}
+
+type exportData struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+func (h *harvester) registerExportHandlers() {
+	h.registerRPC("timesheet.export", func(payload json.RawMessage) (interface{}, error) {
+		var req exportRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return nil, err
+		}
+		if req.Format == exportPDF {
+			return nil, &rpcError{Code: rpcErrBadRequest, Message: "pdfs are printed from the html report"}
+		}
+
+		start, end, err := req.period()
+		if err != nil {
+			return nil, err
+		}
+		timesheet, err := h.getTimeSheet(start.UTC(), end.UTC(), req.GroupBy)
+		if err != nil {
+			return nil, err
+		}
+
+		var content strings.Builder
+		if err := h.exportTimesheet(&content, timesheet, req.Format); err != nil {
+			return nil, err
+		}
+		return exportData{
+			Name:    timesheet.fileName(req.Format),
+			Content: content.String(),
+		}, nil
+	})
+}
+
+// writeExportFile writes the export to path, leaving no partial file behind
+// when it fails.
+func writeExportFile(path, format string, export func(w io.Writer) error) error {
+	if err := checkExportFormat(format); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = export(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// checkExportFormat fails for formats timesheets can not be exported to.
+func checkExportFormat(format string) error {
+	switch format {
+	case exportCSV, exportJSON, exportHTML, exportPDF:
+		return nil
+	}
+	return &rpcError{Code: rpcErrBadRequest, Message: "unknown export format " + format}
+}
+
+func (h *harvester) exportTimesheet(w io.Writer, timesheet *TimeSheet, format string) error {
+	switch format {
+	case exportHTML:
+		return reportTemplate.Execute(w, h.timesheetReport(timesheet))
+	case exportPDF:
+		return writeReportPDF(w, h.timesheetReport(timesheet))
+	}
+	return exportTimesheetData(w, timesheet, format)
+}
+
+// exportTimesheetData writes the exports that need nothing but the timesheet,
+// reports list the descriptions of the keys as well.
+func exportTimesheetData(w io.Writer, timesheet *TimeSheet, format string) error {
+	switch format {
+	case exportCSV:
+		return writeTimesheetCSV(w, timesheet)
+	case exportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(timesheet)
+	}
+	return checkExportFormat(format)
+}
+
+// fileName is the default name of the export of the timesheet.
+func (t *TimeSheet) fileName(format string) string {
+	start := t.TimeStart.Local().Format("2006-01-02")
+	end := t.TimeEnd.Local().Format("2006-01-02")
+	if start == end {
+		return fmt.Sprintf("timesheet-%s.%s", start, format)
+	}
+	return fmt.Sprintf("timesheet-%s-%s.%s", start, end, format)
+}
+
+// writeTimesheetCSV writes a row per key or group with a column per day.
+func writeTimesheetCSV(w io.Writer, timesheet *TimeSheet) error {
+	hours := func(h float64) string {
+		return fmt.Sprintf("%.2f", h)
+	}
+
+	out := csv.NewWriter(w)
+
+	header := []string{timesheet.GroupBy}
+	for _, day := range timesheet.Days {
+		header = append(header, day.Local().Format("2006-01-02"))
+	}
+	header = append(header, "total", "notes")
+	if err := out.Write(header); err != nil {
+		return err
+	}
+
+	for _, task := range timesheet.Tasks {
+		row := []string{task.Key}
+		for _, d := range task.Durations {
+			row = append(row, hours(d))
+		}
+		row = append(row, hours(task.TotalTime), task.Notes)
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+
+	total := []string{"total"}
+	for _, d := range timesheet.DaysTotal {
+		total = append(total, hours(d))
+	}
+	total = append(total, hours(timesheet.Total), "")
+	if err := out.Write(total); err != nil {
+		return err
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+type reportLine struct {
+	Key         string
+	Description string
+	Notes       []string
+	Hours       float64
+}
+
+type reportData struct {
+	Title     string
+	Period    string
+	GroupBy   string
+	Generated time.Time
+	Lines     []reportLine
+	Total     float64
+}
+
+// timesheetReport is the data of a printable invoice style report with a
+// line per key or group.
+func (h *harvester) timesheetReport(timesheet *TimeSheet) reportData {
+	start := timesheet.TimeStart.Local()
+	end := timesheet.TimeEnd.Local()
+
+	data := reportData{
+		Title:     "Timesheet",
+		Period:    start.Format("Jan 2, 2006"),
+		GroupBy:   strings.Title(timesheet.GroupBy),
+		Generated: time.Now(),
+		Total:     timesheet.Total,
+	}
+	if daysBetween(start, end) > 0 {
+		data.Period += " - " + end.Format("Jan 2, 2006")
+	}
+
+	for _, task := range timesheet.Tasks {
+		line := reportLine{
+			Key:         task.Key,
+			Description: h.timesheetDescription(task),
+			Hours:       task.TotalTime,
+		}
+		if task.Notes != "" {
+			line.Notes = strings.Split(task.Notes, "\n")
+		}
+		data.Lines = append(data.Lines, line)
+	}
+	return data
+}
+
+// timesheetDescription describes the time of a key with the title of its
+// issue, task or project, groups list their keys.
+func (h *harvester) timesheetDescription(task TaskTimeInfo) string {
+	if len(task.Keys) > 0 {
+		return strings.Join(task.Keys, ", ")
+	}
+
+	timer, err := h.Timers.GetByKey(task.Key)
+	switch {
+	case err != nil:
+		return ""
+	case timer.Custom != nil:
+		return timer.Custom.Title
+	case timer.Issue != nil:
+		return timer.Issue.Summary
+	case timer.Project != nil:
+		return timer.Project.Name
+	}
+	return ""
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"hours": func(h float64) string {
+		return fmt.Sprintf("%.2f", h)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} {{.Period}}</title>
+<style>
+	body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; font-size: 12px; color: #212529; margin: 40px; }
+	h1 { font-size: 24px; margin: 0 0 4px 0; }
+	.period { color: #6c757d; margin-bottom: 24px; }
+	table { width: 100%; border-collapse: collapse; }
+	th { text-align: left; border-bottom: 2px solid #212529; padding: 6px 4px; }
+	td { border-bottom: 1px solid #dee2e6; padding: 6px 4px; vertical-align: top; }
+	.hours { text-align: right; white-space: nowrap; }
+	.notes { color: #6c757d; }
+	.total td { border-bottom: none; border-top: 2px solid #212529; font-weight: bold; }
+	.generated { color: #6c757d; margin-top: 24px; font-size: 10px; }
+	@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="period">{{.Period}}</div>
+<table>
+	<thead>
+		<tr><th>{{.GroupBy}}</th><th>Description</th><th class="hours">Hours</th></tr>
+	</thead>
+	<tbody>
+	{{- range .Lines}}
+		<tr>
+			<td>{{.Key}}</td>
+			<td>{{.Description}}{{range .Notes}}<div class="notes">{{.}}</div>{{end}}</td>
+			<td class="hours">{{hours .Hours}}</td>
+		</tr>
+	{{- end}}
+		<tr class="total"><td>Total</td><td></td><td class="hours">{{hours .Total}}</td></tr>
+	</tbody>
+</table>
+<div class="generated">Generated {{.Generated.Format "Jan 2, 2006 15:04"}}</div>
+</body>
+</html>
+`))
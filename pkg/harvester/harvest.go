@@ -2,162 +2,424 @@ package harvester
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/becoded/go-harvest/harvest"
 	"golang.org/x/oauth2"
 )
 
-type HarvestClient struct {
-	*harvest.HarvestClient
+const (
+	// Ways of authenticating with harvest, a personal access token is used
+	// when none is set
+	harvestAuthToken = "token"
+	harvestAuthOAuth = "oauth"
+
+	harvestAccountsURL = "https://id.getharvest.com/api/v2/accounts"
+)
+
+var harvestEndpoint = oauth2.Endpoint{
+	AuthURL:  "https://id.getharvest.com/oauth2/authorize",
+	TokenURL: "https://id.getharvest.com/api/v2/oauth2/token",
 }
 
-type harvestTask struct {
-	harvest.UserProjectAssignment
-	timer *harvest.TimeEntry
+// harvestAccount is an account the oauth login can track time in.
+type harvestAccount struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Product string `json:"product"`
 }
 
-type harvestTasks []*harvestTask
+// harvestBackend logs time to harvest.
+type harvestBackend struct {
+	client    *harvest.HarvestClient
+	timesheet harvestTimesheet
+}
 
-func (t harvestTasks) getByKey(key string) (*harvestTask, error) {
-	for _, task := range t {
-		if *task.Project.Code == key {
-			return task, nil
+// harvestTimesheet is the part of the harvest timesheet api used to track
+// time, it is satisfied by the Timesheet service of the client.
+type harvestTimesheet interface {
+	List(ctx context.Context, opt *harvest.TimeEntryListOptions) (*harvest.TimeEntryList, *http.Response, error)
+	CreateTimeEntryViaDuration(ctx context.Context, data *harvest.TimeEntryCreateViaDuration) (*harvest.TimeEntry, *http.Response, error)
+	UpdateTimeEntry(ctx context.Context, timeEntryId int64, data *harvest.TimeEntryUpdate) (*harvest.TimeEntry, *http.Response, error)
+	StopTimeEntry(ctx context.Context, timeEntryId int64) (*harvest.TimeEntry, *http.Response, error)
+	RestartTimeEntry(ctx context.Context, timeEntryId int64) (*harvest.TimeEntry, *http.Response, error)
+}
+
+func (h *harvester) registerHarvestHandlers() {
+	h.registerRPC("harvest.login", func(payload json.RawMessage) (interface{}, error) {
+		var data SettingsData
+		if err := decodePayload(payload, &data); err != nil {
+			return nil, err
 		}
-	}
+		if data.ClientID == "" || data.ClientSecret == "" {
+			return nil, &rpcError{Code: rpcErrBadRequest, Message: "client id and secret are required"}
+		}
+
+		config := harvestOAuthConfig(&data)
+		return nil, h.startOAuth(config, func(token *oauth2.Token) error {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			accounts, err := getHarvestAccounts(config.Client(ctx, token))
+			if err != nil {
+				return err
+			}
+			if len(accounts) == 0 {
+				return errors.New("the login has no harvest accounts")
+			}
+
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			harvestSettings := h.Settings.Harvest
+			harvestSettings.Auth = harvestAuthOAuth
+			harvestSettings.ClientID = data.ClientID
+			harvestSettings.ClientSecret = data.ClientSecret
+			if err := harvestSettings.setOAuthToken(token); err != nil {
+				return err
+			}
+
+			// Keep the chosen account if the login still has it
+			harvestSettings.User = strconv.FormatInt(accounts[0].ID, 10)
+			for _, account := range accounts {
+				if strconv.FormatInt(account.ID, 10) == h.Settings.Harvest.User {
+					harvestSettings.User = h.Settings.Harvest.User
+				}
+			}
 
-	return nil, fmt.Errorf("no task with key %s found", key)
+			h.Settings.Harvest = harvestSettings
+			h.settingsChanged()
+			return nil
+		})
+	})
+
+	// Lists the accounts of the oauth login to choose the one to track time in
+	h.registerRPC("harvest.accounts", func(json.RawMessage) (interface{}, error) {
+		if h.Settings.Harvest.Auth != harvestAuthOAuth {
+			return []harvestAccount{}, nil
+		}
+
+		client, err := h.oauthClient(harvestOAuthConfig(&h.Settings.Harvest), &h.Settings.Harvest, http.DefaultTransport)
+		if err != nil {
+			return nil, err
+		}
+		return getHarvestAccounts(client)
+	})
 }
 
 func (h *harvester) getNewHarvestClient() error {
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{
-			AccessToken: h.Settings.Harvest.Pass,
-		},
-	)
-	tc := oauth2.NewClient(context.Background(), ts)
+	var tc *http.Client
+	if h.Settings.Harvest.Auth == harvestAuthOAuth {
+		var err error
+		tc, err = h.oauthClient(harvestOAuthConfig(&h.Settings.Harvest), &h.Settings.Harvest, http.DefaultTransport)
+		if err != nil {
+			return err
+		}
+	} else {
+		ts := oauth2.StaticTokenSource(
+			&oauth2.Token{
+				AccessToken: h.Settings.Harvest.Pass,
+			},
+		)
+		tc = oauth2.NewClient(context.Background(), ts)
+	}
 
 	service := harvest.NewHarvestClient(tc)
 	service.AccountId = h.Settings.Harvest.User
 
-	client := &HarvestClient{
-		HarvestClient: service,
+	h.backend = &harvestBackend{
+		client:    service,
+		timesheet: service.Timesheet,
 	}
-
-	h.harvestClient = client
+	h.backendURL = nil
 	return nil
 }
 
-func (c *HarvestClient) getCompany() (*harvest.Company, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// harvestConfigured reports if data has what the auth needs to connect.
+func harvestConfigured(data *SettingsData) bool {
+	if data.Auth == harvestAuthOAuth {
+		return data.Token != "" && data.User != ""
+	}
+	return data.User != "" && data.Pass != ""
+}
+
+func harvestOAuthConfig(data *SettingsData) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     data.ClientID,
+		ClientSecret: data.ClientSecret,
+		Endpoint:     harvestEndpoint,
+	}
+}
 
-	company, _, err := c.Company.Get(ctx)
+// getHarvestAccounts returns the harvest accounts of the login, leaving out
+// accounts of other products.
+func getHarvestAccounts(client *http.Client) ([]harvestAccount, error) {
+	resp, err := client.Get(harvestAccountsURL)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
-	return company, nil
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to list harvest accounts: %s", resp.Status)
+	}
+
+	var body struct {
+		Accounts []harvestAccount `json:"accounts"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	accounts := make([]harvestAccount, 0, len(body.Accounts))
+	for _, account := range body.Accounts {
+		if account.Product == "harvest" {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts, nil
 }
 
-func (c *HarvestClient) getUserProjects() (harvestTasks, error) {
+func (b *harvestBackend) Name() string {
+	return "Harvest"
+}
+
+func (b *harvestBackend) URL() (*url.URL, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	asignments, _, err := c.Project.GetMyProjectAssignments(ctx, nil)
+	company, _, err := b.client.Company.Get(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	tasks := make(harvestTasks, 0)
-	for _, a := range asignments.UserAssignments {
-		if *a.IsProjectManager == false {
+	return url.Parse(*company.BaseUri)
+}
+
+func (b *harvestBackend) Projects() (Projects, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var assignments []*harvest.UserProjectAssignment
+	opts := &harvest.MyProjectAssignmentListOptions{}
+	for {
+		list, _, err := b.client.Project.GetMyProjectAssignments(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		assignments = append(assignments, list.UserAssignments...)
+		if list.NextPage == nil {
+			break
+		}
+		opts.Page = *list.NextPage
+	}
+
+	projects := make(Projects, 0)
+	for _, a := range assignments {
+		if a.IsActive != nil && !*a.IsActive {
 			continue
 		}
 
-		task := &harvestTask{
-			UserProjectAssignment: *a,
+		project := &Project{
+			ID:   *a.Project.Id,
+			Name: *a.Project.Name,
+		}
+		if a.Project.Code != nil {
+			project.Code = *a.Project.Code
+		}
+		if a.Client != nil && a.Client.Name != nil {
+			project.Client = *a.Client.Name
+		}
+		if a.TaskAssignments != nil {
+			for _, t := range *a.TaskAssignments {
+				project.Tasks = append(project.Tasks, ProjectTask{
+					ID:   *t.Task.Id,
+					Name: *t.Task.Name,
+				})
+			}
 		}
 
-		tasks = append(tasks, task)
+		projects = append(projects, project)
 	}
+	return projects, nil
+}
 
-	timers, err := c.getTimers()
+func (b *harvestBackend) StartTimer(projectID, taskID int64, notes string) (*Entry, error) {
+	ctx, c := context.WithTimeout(context.Background(), 10*time.Second)
+	defer c()
+
+	entry, _, err := b.timesheet.CreateTimeEntryViaDuration(ctx, &harvest.TimeEntryCreateViaDuration{
+		ProjectId: &projectID,
+		TaskId:    &taskID,
+		SpentDate: harvestDate(time.Now()),
+		Notes:     optionalString(notes),
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	for _, task := range tasks {
-		for _, timer := range timers {
-			if *timer.Project.Id == *task.Project.Id {
-				task.timer = timer
-				break
-			}
-		}
-	}
-	return tasks, nil
+	return harvestEntry(entry)
 }
 
-func (c *HarvestClient) getTimers() ([]*harvest.TimeEntry, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+func (b *harvestBackend) StopTimer(entryID int64) (*Entry, error) {
+	ctx, c := context.WithTimeout(context.Background(), 10*time.Second)
+	defer c()
 
-	isRunning := true
-	times, _, err := c.Timesheet.List(ctx, &harvest.TimeEntryListOptions{
-		IsRunning: &isRunning,
-	})
+	entry, _, err := b.timesheet.StopTimeEntry(ctx, entryID)
 	if err != nil {
 		return nil, err
 	}
 
-	return times.TimeEntries, nil
+	return harvestEntry(entry)
 }
 
-func (t *harvestTask) startTimer(client *HarvestClient) error {
-	if t.timer != nil {
-		return nil
+func (b *harvestBackend) RestartTimer(entryID int64) (*Entry, error) {
+	ctx, c := context.WithTimeout(context.Background(), 10*time.Second)
+	defer c()
+
+	entry, _, err := b.timesheet.RestartTimeEntry(ctx, entryID)
+	if err != nil {
+		return nil, err
 	}
 
-	// Get the coding task
-	var codingTask *harvest.ProjectTaskAssignment
-	for _, t := range *t.TaskAssignments {
-		if *t.Task.Name == "Coding" {
-			codingTask = &t
-			break
+	return harvestEntry(entry)
+}
+
+func (b *harvestBackend) ListEntries(from time.Time) ([]*Entry, error) {
+	return b.listEntries(&harvest.TimeEntryListOptions{
+		From: harvestDate(from),
+	})
+}
+
+func (b *harvestBackend) RunningEntries() ([]*Entry, error) {
+	isRunning := true
+	return b.listEntries(&harvest.TimeEntryListOptions{
+		IsRunning: &isRunning,
+	})
+}
+
+func (b *harvestBackend) listEntries(opts *harvest.TimeEntryListOptions) ([]*Entry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var entries []*Entry
+	for {
+		list, _, err := b.timesheet.List(ctx, opts)
+		if err != nil {
+			return nil, err
 		}
+
+		for _, e := range list.TimeEntries {
+			entry, err := harvestEntry(e)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+
+		if list.NextPage == nil {
+			return entries, nil
+		}
+		opts.Page = *list.NextPage
 	}
-	if codingTask == nil {
-		return errors.New("unable to find coding task")
-	}
+}
 
-	ctx, c := context.WithTimeout(context.Background(), 10*time.Second)
+func (b *harvestBackend) CreateEntry(projectID, taskID int64, day time.Time, hours float64, notes string) (*Entry, error) {
+	ctx, c := context.WithTimeout(context.Background(), time.Minute)
 	defer c()
 
-	entry, _, err := client.Timesheet.CreateTimeEntryViaDuration(ctx, &harvest.TimeEntryCreateViaDuration{
-		ProjectId: t.Project.Id,
-		TaskId:    codingTask.Task.Id,
-		SpentDate: &harvest.Date{Time: time.Now()},
+	entry, _, err := b.timesheet.CreateTimeEntryViaDuration(ctx, &harvest.TimeEntryCreateViaDuration{
+		ProjectId: &projectID,
+		TaskId:    &taskID,
+		Hours:     &hours,
+		SpentDate: harvestDate(day),
+		Notes:     optionalString(notes),
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	t.timer = entry
-	return nil
+	return harvestEntry(entry)
 }
 
-func (t *harvestTask) stopTimer(client *HarvestClient) error {
-	if t.timer == nil {
-		return nil
-	}
+func (b *harvestBackend) UpdateEntry(entryID int64, hours float64, notes string) (*Entry, error) {
+	return b.updateEntry(entryID, &harvest.TimeEntryUpdate{
+		Hours: &hours,
+		Notes: optionalString(notes),
+	})
+}
 
-	ctx, c := context.WithTimeout(context.Background(), 10*time.Second)
+func (b *harvestBackend) UpdateNotes(entryID int64, notes string) (*Entry, error) {
+	return b.updateEntry(entryID, &harvest.TimeEntryUpdate{
+		Notes: &notes,
+	})
+}
+
+func (b *harvestBackend) updateEntry(entryID int64, update *harvest.TimeEntryUpdate) (*Entry, error) {
+	ctx, c := context.WithTimeout(context.Background(), time.Minute)
 	defer c()
 
-	_, _, err := client.Timesheet.StopTimeEntry(ctx, *t.timer.Id)
+	entry, _, err := b.timesheet.UpdateTimeEntry(ctx, entryID, update)
+	if err != nil {
+		return nil, err
+	}
+
+	return harvestEntry(entry)
+}
+
+// optionalString leaves empty strings out of requests.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// harvestDate is the calendar date of day. Dates are sent as timestamps so
+// midnight UTC is used to keep harvest from moving the entry to another day.
+func harvestDate(day time.Time) *harvest.Date {
+	return &harvest.Date{
+		Time: time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC),
+	}
+}
 
-	t.timer = nil
-	return err
+func harvestEntry(e *harvest.TimeEntry) (*Entry, error) {
+	entry := &Entry{
+		ID: *e.Id,
+	}
+	if e.Project != nil {
+		if e.Project.Id != nil {
+			entry.ProjectID = *e.Project.Id
+		}
+		if e.Project.Code != nil {
+			entry.Code = *e.Project.Code
+		}
+	}
+	if e.Task != nil && e.Task.Id != nil {
+		entry.TaskID = *e.Task.Id
+	}
+	if e.Hours != nil {
+		entry.Hours = *e.Hours
+	}
+	if e.Notes != nil {
+		entry.Notes = *e.Notes
+	}
+	if e.IsRunning != nil {
+		entry.Running = *e.IsRunning
+	}
+	if e.SpentDate != nil {
+		day, err := time.ParseInLocation("2006-01-02", e.SpentDate.Format("2006-01-02"), time.Local)
+		if err != nil {
+			return nil, err
+		}
+		entry.Day = day
+	}
+	return entry, nil
 }
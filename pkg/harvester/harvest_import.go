@@ -1,134 +1,747 @@
 package harvester
 
 import (
-	"context"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
 	"math"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/becoded/go-harvest/harvest"
 	"github.com/dgraph-io/badger"
+	"github.com/jinzhu/now"
 )
 
-type harvestEntries []*harvest.TimeEntry
+const (
+	backfillLogPrefix = "backfill.log."
 
-func (h harvestEntries) getByKeyAndTime(key string, date time.Time) (*harvest.TimeEntry, error) {
-	for _, e := range h {
-		if *e.Project.Code == key && date.YearDay() == e.SpentDate.YearDay() {
-			return e, nil
+	backfillCreate   = "create"
+	backfillUpdate   = "update"
+	backfillImport   = "import"
+	backfillConflict = "conflict"
+
+	// Strategies for days where both the local and harvest hours changed
+	syncLocal  = "local"
+	syncRemote = "remote"
+	syncMax    = "max"
+	syncManual = "manual"
+
+	// maxBackfillLog is the most log entries returned for review
+	maxBackfillLog = 200
+
+	// backfillLogTime keeps a fixed width so log keys sort by time
+	backfillLogTime = "20060102150405.000000000"
+)
+
+// remoteDay is the time logged in the backend for a key on a local day.
+type remoteDay struct {
+	Key     string
+	Day     time.Time
+	Hours   float64
+	Running bool
+
+	// Notes are the notes of every entry of the day
+	Notes []string
+
+	// Entry is the entry adjusted when the hours of the day are updated
+	Entry *Entry
+}
+
+// notes joins the notes of the entries of the day.
+func (d *remoteDay) notes() string {
+	var notes []string
+	for _, n := range d.Notes {
+		if n != "" && !containsString(notes, n) {
+			notes = append(notes, n)
+		}
+	}
+	return strings.Join(notes, "\n")
+}
+
+// remoteDays totals the entries by the database key of the stored timer
+// tracking the same key and day.
+func remoteDays(entries []*Entry) map[string]*remoteDay {
+	days := make(map[string]*remoteDay)
+	for _, e := range entries {
+		if e.Code == "" {
+			continue
+		}
+
+		dbKey := string(storedTimerKey(e.Code, e.Day))
+		d, ok := days[dbKey]
+		if !ok {
+			d = &remoteDay{
+				Key:   e.Code,
+				Day:   e.Day,
+				Entry: e,
+			}
+			days[dbKey] = d
 		}
+
+		d.Hours += e.Hours
+		d.Running = d.Running || e.Running
+		d.Notes = append(d.Notes, e.Notes)
 	}
+	return days
+}
 
-	return nil, fmt.Errorf("No entry with key %s and time %s found", key, date.String())
+// backfillChange is a single change planned to bring the local time and
+// the backend in line. Creates and updates are made in the backend, imports
+// replace the local time with the backend hours and conflicts wait for the user to
+// choose a side.
+type backfillChange struct {
+	Action      string    `json:"action"`
+	Key         string    `json:"key"`
+	Day         time.Time `json:"day"`
+	LocalHours  float64   `json:"localHours"`
+	RemoteHours float64   `json:"remoteHours"`
+	EntryID     int64     `json:"entryId,omitempty"`
+	EntryHours  float64   `json:"entryHours,omitempty"`
+	ProjectID   int64     `json:"projectId,omitempty"`
+	TaskID      int64     `json:"taskId,omitempty"`
+	Notes       string    `json:"notes,omitempty"`
+
+	// Resolved is the side the user chose for a conflict
+	Resolved string `json:"resolved,omitempty"`
+}
+
+// hours returns the hours both sides have once the change is applied.
+func (c backfillChange) hours() float64 {
+	if c.Action == backfillImport {
+		return c.RemoteHours
+	}
+	return c.LocalHours
 }
 
-func (h *harvester) backfillHarvest() error {
-	if h.harvestClient == nil {
+// resolve settles a conflict by keeping the local or the backend hours.
+func (c *backfillChange) resolve(use string) error {
+	if c.Action != backfillConflict {
+		return &rpcError{Code: rpcErrBadRequest, Message: "change is not a conflict"}
+	}
+
+	switch use {
+	case syncLocal:
+		c.Action = backfillUpdate
+	case syncRemote:
+		c.Action = backfillImport
+	default:
+		return &rpcError{Code: rpcErrBadRequest, Message: fmt.Sprintf("unknown side %s", use)}
+	}
+	c.Resolved = use
+	return nil
+}
+
+// keepResolutions resolves the conflicts of changes the way the user
+// resolved them in the previous plan, as long as neither side's hours
+// changed much since.
+func keepResolutions(previous, changes []backfillChange) []backfillChange {
+	resolved := make(map[string]backfillChange)
+	for _, change := range previous {
+		if change.Resolved != "" {
+			resolved[string(storedTimerKey(change.Key, change.Day))] = change
+		}
+	}
+
+	for i := range changes {
+		change := &changes[i]
+		if change.Action != backfillConflict {
+			continue
+		}
+
+		old, ok := resolved[string(storedTimerKey(change.Key, change.Day))]
+		if !ok || !hoursMatch(change.LocalHours, old.LocalHours) || !hoursMatch(change.RemoteHours, old.RemoteHours) {
+			continue
+		}
+		change.resolve(old.Resolved)
+	}
+	return changes
+}
+
+// backfillLogEntry records an applied change.
+type backfillLogEntry struct {
+	AppliedAt time.Time      `json:"appliedAt"`
+	Change    backfillChange `json:"change"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// backfillPlanRequest names the plan the user reviewed by its id.
+type backfillPlanRequest struct {
+	PlanID string `json:"planId"`
+}
+
+type backfillResolveRequest struct {
+	backfillPlanRequest
+	Index int    `json:"index"`
+	Use   string `json:"use"`
+}
+
+// backfillPlanID identifies the planned changes so the user only approves
+// the plan they saw.
+func backfillPlanID(changes []backfillChange) string {
+	data, _ := json.Marshal(changes)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// checkBackfillPlan fails when the pending plan is not the one reviewed,
+// showing the current plan for another review.
+func (h *harvester) checkBackfillPlan(id string) error {
+	if id == backfillPlanID(h.pendingBackfill) {
 		return nil
 	}
 
-	log.Println("harvest backfill start")
+	if err := h.renderBackfill(); err != nil {
+		return err
+	}
+	return &rpcError{Code: rpcErrBadRequest, Message: "the planned changes were updated, review them again"}
+}
+
+func (h *harvester) registerBackfillHandlers() {
+	h.registerRPC("backfill.plan", func(json.RawMessage) (interface{}, error) {
+		if err := h.planPendingBackfill(); err != nil {
+			return nil, err
+		}
+		return nil, h.renderBackfill()
+	})
 
-	ctx, c := context.WithTimeout(context.Background(), time.Minute)
-	defer c()
+	h.registerRPC("backfill.resolve", func(payload json.RawMessage) (interface{}, error) {
+		var req backfillResolveRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return nil, err
+		}
+		if err := h.checkBackfillPlan(req.PlanID); err != nil {
+			return nil, err
+		}
 
-	timesheet, _, err := h.harvestClient.Timesheet.List(ctx, &harvest.TimeEntryListOptions{
-		From: &harvest.Date{Time: time.Now().Add(-35 * 24 * time.Hour)},
+		if req.Index < 0 || req.Index >= len(h.pendingBackfill) {
+			return nil, &rpcError{Code: rpcErrBadRequest, Message: "no planned change to resolve"}
+		}
+		if err := h.pendingBackfill[req.Index].resolve(req.Use); err != nil {
+			return nil, err
+		}
+		return nil, h.renderBackfill()
 	})
+
+	// Applies the plan the user reviewed, a newer plan needs reviewing first.
+	// Unresolved conflicts are kept for later.
+	h.registerRPC("backfill.apply", func(payload json.RawMessage) (interface{}, error) {
+		var req backfillPlanRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return nil, err
+		}
+		if err := h.checkBackfillPlan(req.PlanID); err != nil {
+			return nil, err
+		}
+
+		var changes, conflicts []backfillChange
+		for _, change := range h.pendingBackfill {
+			if change.Action == backfillConflict {
+				conflicts = append(conflicts, change)
+				continue
+			}
+			changes = append(changes, change)
+		}
+		h.pendingBackfill = conflicts
+
+		if err := h.applyBackfill(changes); err != nil {
+			return nil, err
+		}
+		if len(conflicts) > 0 {
+			return nil, h.renderBackfill()
+		}
+		return nil, h.renderMainWindow()
+	})
+
+	h.registerRPC("backfill.dismiss", func(json.RawMessage) (interface{}, error) {
+		h.pendingBackfill = nil
+		return nil, h.renderMainWindow()
+	})
+
+	h.registerRPC("backfill.log", func(json.RawMessage) (interface{}, error) {
+		return getBackfillLog(h.db, maxBackfillLog)
+	})
+}
+
+// backfill plans the changes needed to sync the local time with the backend
+// and holds them until they are approved. A plan under review is kept so
+// the changes do not move under the user.
+func (h *harvester) backfill() error {
+	if h.reviewingBackfill() {
+		return nil
+	}
+	return h.planPendingBackfill()
+}
+
+// reviewingBackfill reports if the window shows the pending plan.
+func (h *harvester) reviewingBackfill() bool {
+	return h.mainWindow != nil && h.mainWindow.View == "backfill" && len(h.pendingBackfill) > 0
+}
+
+// planPendingBackfill replaces the pending plan with a new one.
+func (h *harvester) planPendingBackfill() error {
+	if h.backend == nil {
+		return nil
+	}
+
+	changes, err := h.planBackfill()
 	if err != nil {
 		return err
 	}
 
-	var harvestEntries harvestEntries
-	for _, e := range timesheet.TimeEntries {
-		if *e.UserAssignment.IsProjectManager && *e.Hours > 0 {
-			harvestEntries = append(harvestEntries, e)
-		}
+	h.pendingBackfill = keepResolutions(h.pendingBackfill, changes)
+	h.sendTimers(false, false)
+	return nil
+}
+
+// planBackfill compares the stored timers with the backend entries of the
+// synced weeks without changing anything.
+func (h *harvester) planBackfill() ([]backfillChange, error) {
+	log.Println("backfill plan start")
+
+	from := now.BeginningOfDay().AddDate(0, 0, -7*h.Settings.syncWeeks())
+
+	entries, err := h.backend.ListEntries(from)
+	if err != nil {
+		return nil, err
 	}
 
 	storedTimers, err := getTimersByOpts(h.db, badger.DefaultIteratorOptions)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	harvestTasks, err := h.harvestClient.getUserProjects()
+	projects, err := h.getIncludedProjects()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	mapping, err := getTaskMapping(h.db)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, task := range storedTimers {
-		if math.Round(task.Duration.Hours()*100)/100 == 0 {
+	customs, err := getCustomTasks(h.db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Issues of keys without a timer are looked up once per plan
+	issues := &keyLookup{h: h}
+	issueOf := func(key string) *Issue {
+		if timer, err := h.Timers.GetByKey(key); err == nil && timer.Issue != nil {
+			return timer.Issue
+		}
+		return issues.issue(key)
+	}
+
+	changes, err := planSync(storedTimers, entries, projects, customs, mapping, issueOf, h.uncommittedDays(), from, h.Settings.syncStrategy())
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("backfill plan complete with %d changes", len(changes))
+	return changes, nil
+}
+
+// uncommittedDays returns the database keys of the days timers have time on
+// that is not stored yet. The backend entries of a paused timer already have
+// that time, so those days are compared once the timer is stopped.
+func (h *harvester) uncommittedDays() map[string]bool {
+	days := make(map[string]bool)
+	for _, timer := range h.Timers {
+		for _, interval := range timer.uncommitted(time.Now().UTC()) {
+			for _, part := range splitAtMidnight(interval) {
+				days[string(storedTimerKey(timer.Key, part.Start.Local()))] = true
+			}
+		}
+	}
+	return days
+}
+
+// planSync plans the changes for the stored timers and backend entries from
+// the day from onwards, leaving out the uncommitted days. Only keys of the
+// projects and custom tasks are synced, new entries are logged to the
+// project of the key using the task chosen by mapping for the issue of the
+// key, as live timers do.
+func planSync(
+	storedTimers StoredTimers,
+	entries []*Entry,
+	projects Projects,
+	customs CustomTasks,
+	mapping *TaskMapping,
+	issueOf func(key string) *Issue,
+	uncommitted map[string]bool,
+	from time.Time,
+	strategy string,
+) ([]backfillChange, error) {
+	projects, entries = customs.syncTargets(projects, entries)
+	remote := remoteDays(entries)
+
+	changes := make([]backfillChange, 0)
+	for i := range storedTimers {
+		local := &storedTimers[i]
+		if local.Day.Before(from) {
 			continue
 		}
 
-		_, err := harvestTasks.getByKey(task.Key)
+		dbKey := string(storedTimerKey(local.Key, local.Day))
+		day := remote[dbKey]
+		delete(remote, dbKey)
+		if uncommitted[dbKey] {
+			continue
+		}
+
+		project, err := projects.getByKey(local.Key)
 		if err != nil {
 			continue
 		}
 
-		entry, _ := harvestEntries.getByKeyAndTime(task.Key, task.Day)
-		if entry != nil {
-			if *entry.IsRunning {
-				continue
+		change, ok := planSyncChange(local, day, strategy)
+		if !ok {
+			continue
+		}
+
+		if change.Action == backfillCreate {
+			timer := &TaskTimer{
+				Key:     local.Key,
+				Project: project,
+				Custom:  customs.getByKey(local.Key),
+			}
+			if timer.Custom == nil && issueOf != nil {
+				timer.Issue = issueOf(local.Key)
 			}
 
-			if hoursMatch(*entry.Hours, task.Duration.Hours()) {
+			taskID, err := mapping.taskID(timer)
+			if err != nil {
+				log.Println(err)
 				continue
 			}
 
-			hourAdjust := task.Duration.Hours() - *entry.Hours
-			if *entry.Hours > task.Duration.Hours() {
-				hourAdjust = -1 * hourAdjust
-			}
-			newTime := *entry.Hours + hourAdjust
-
-			log.Printf(
-				"Updating from %.2f to %.2f (%.2f) for key %s on %s\n",
-				*entry.Hours,
-				task.Duration.Hours(),
-				hourAdjust,
-				task.Key,
-				task.Day.Format("2006-01-02"),
-			)
-
-			ctx, c := context.WithTimeout(context.Background(), time.Minute)
-			defer c()
-			_, _, err := h.harvestClient.Timesheet.UpdateTimeEntry(ctx, *entry.Id, &harvest.TimeEntryUpdate{
-				Hours: &newTime,
+			change.ProjectID = project.ID
+			change.TaskID = taskID
+		}
+
+		changes = append(changes, change)
+	}
+
+	// Whatever is left was only logged in the backend
+	for dbKey, day := range remote {
+		if day.Day.Before(from) || uncommitted[dbKey] {
+			continue
+		}
+		if _, err := projects.getByKey(day.Key); err != nil {
+			continue
+		}
+
+		if change, ok := planSyncChange(nil, day, strategy); ok {
+			changes = append(changes, change)
+		}
+	}
+
+	sort.SliceStable(changes, func(a, b int) bool {
+		if !changes[a].Day.Equal(changes[b].Day) {
+			return changes[a].Day.Before(changes[b].Day)
+		}
+		return changes[a].Key < changes[b].Key
+	})
+
+	return changes, nil
+}
+
+// planSyncChange decides how to sync the time of a key on a day, local and
+// remote are nil when the day was not tracked on that side. The hours synced
+// last time tell which side changed, when both did the strategy is used.
+func planSyncChange(local *StoredTimer, remote *remoteDay, strategy string) (backfillChange, bool) {
+	var change backfillChange
+	if remote != nil {
+		// Running entries are still changing so wait until they stop
+		if remote.Running {
+			return change, false
+		}
+
+		change.Key = remote.Key
+		change.Day = remote.Day
+		change.RemoteHours = remote.Hours
+		change.EntryID = remote.Entry.ID
+		change.EntryHours = remote.Entry.Hours
+	}
+	if local != nil {
+		change.Key = local.Key
+		change.Day = local.Day
+		change.LocalHours = local.Duration.Hours()
+	}
+
+	change.Action = planSyncAction(local, remote, &change, strategy)
+	switch change.Action {
+	case "":
+		return change, false
+	case backfillCreate:
+		change.Notes = local.Notes
+	case backfillImport:
+		if remote != nil {
+			change.Notes = remote.notes()
+		}
+	default:
+		change.Notes = notesToPush(local, remote)
+	}
+	return change, true
+}
+
+// planSyncAction returns the action syncing the hours of change, none when
+// they match. Days with matching hours are updated to push edited notes.
+func planSyncAction(local *StoredTimer, remote *remoteDay, change *backfillChange, strategy string) string {
+	switch {
+	case remote == nil:
+		// Time removed locally only needs clearing from an existing entry
+		if math.Round(change.LocalHours*100)/100 == 0 {
+			return ""
+		}
+
+		// Entries deleted in the backend since the last sync are removed locally
+		if synced := local.SyncedHours; synced != nil && *synced > 0 && hoursMatch(change.LocalHours, *synced) {
+			return backfillImport
+		}
+		return backfillCreate
+	case local == nil:
+		if math.Round(change.RemoteHours*100)/100 == 0 {
+			return ""
+		}
+		return backfillImport
+	case hoursMatch(change.RemoteHours, change.LocalHours):
+		// Notes edited locally are pushed without changing the hours
+		if notesToPush(local, remote) != "" {
+			change.LocalHours = change.RemoteHours
+			return backfillUpdate
+		}
+		return ""
+	}
+
+	if synced := local.SyncedHours; synced != nil {
+		if hoursMatch(change.LocalHours, *synced) {
+			return backfillImport
+		}
+		if hoursMatch(change.RemoteHours, *synced) {
+			return backfillUpdate
+		}
+	}
+
+	switch strategy {
+	case syncRemote:
+		return backfillImport
+	case syncMax:
+		if change.RemoteHours > change.LocalHours {
+			return backfillImport
+		}
+		return backfillUpdate
+	case syncManual:
+		return backfillConflict
+	}
+	return backfillUpdate
+}
+
+// notesToPush returns the local notes to set on the entry of the day. None
+// are pushed when an entry has them already, they were not edited since the
+// last sync, or they would replace notes typed in the backend.
+func notesToPush(local *StoredTimer, remote *remoteDay) string {
+	if local.Notes == "" || local.Notes == local.SyncedNotes || containsString(remote.Notes, local.Notes) {
+		return ""
+	}
+	if remote.Entry.Notes != "" && remote.Entry.Notes != local.SyncedNotes {
+		return ""
+	}
+	return local.Notes
+}
+
+// applyBackfill makes the approved changes, logging each one.
+func (h *harvester) applyBackfill(changes []backfillChange) error {
+	for _, change := range changes {
+		err := h.applyBackfillChange(change)
+		if err == nil {
+			err = h.markSynced(change)
+		}
+
+		logEntry := backfillLogEntry{
+			AppliedAt: time.Now().UTC(),
+			Change:    change,
+		}
+		if err != nil {
+			logEntry.Error = err.Error()
+		}
+		if logErr := h.saveBackfillLog(logEntry); logErr != nil {
+			return logErr
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *harvester) applyBackfillChange(change backfillChange) error {
+	if change.Action != backfillImport {
+		// Notes typed in the backend are only replaced by local notes
+		if change.Action == backfillCreate && change.Notes == "" {
+			change.Notes = h.defaultNotes(change.Key)
+		}
+		return pushBackfillChange(h.backend, change)
+	}
+
+	log.Printf(
+		"Importing %.2f for key %s on %s\n",
+		change.RemoteHours,
+		change.Key,
+		change.Day.Format("2006-01-02"),
+	)
+
+	duration := time.Duration(change.RemoteHours * float64(time.Hour))
+	return h.modifyStoredTimer(change.Key, change.Day, func(timer *StoredTimer) error {
+		timer.resize(duration, strings.ToLower(h.backend.Name()))
+		timer.addNotes(change.Notes)
+		return nil
+	})
+}
+
+// pushBackfillChange creates or updates the backend entry of the change.
+func pushBackfillChange(backend Backend, change backfillChange) error {
+	switch change.Action {
+	case backfillUpdate:
+		log.Printf(
+			"Updating from %.2f to %.2f for key %s on %s\n",
+			change.RemoteHours,
+			change.LocalHours,
+			change.Key,
+			change.Day.Format("2006-01-02"),
+		)
+
+		// Other entries of the day are left alone so adjust one by the difference
+		hours := math.Max(0, change.EntryHours+change.LocalHours-change.RemoteHours)
+		_, err := backend.UpdateEntry(change.EntryID, hours, change.Notes)
+		return err
+	case backfillCreate:
+		if change.ProjectID == 0 || change.TaskID == 0 {
+			return fmt.Errorf("no project and task to add time for key %s to", change.Key)
+		}
+
+		log.Printf(
+			"Adding %.2f for key %s on %s\n",
+			change.LocalHours,
+			change.Key,
+			change.Day.Format("2006-01-02"),
+		)
+
+		_, err := backend.CreateEntry(change.ProjectID, change.TaskID, change.Day, change.LocalHours, change.Notes)
+		return err
+	case backfillConflict:
+		return fmt.Errorf("choose the hours to keep for key %s first", change.Key)
+	}
+
+	return fmt.Errorf("unknown backfill action %s", change.Action)
+}
+
+// defaultNotes are the notes of time tracked for key without any notes.
+func (h *harvester) defaultNotes(key string) string {
+	if timer, err := h.Timers.GetByKey(key); err == nil {
+		return timer.defaultNotes()
+	}
+	return key
+}
+
+// markSynced records the hours and notes both sides agreed on after the
+// change.
+func (h *harvester) markSynced(change backfillChange) error {
+	return h.modifyStoredTimer(change.Key, change.Day, func(timer *StoredTimer) error {
+		hours := change.hours()
+		timer.SyncedHours = &hours
+		timer.SyncedNotes = timer.Notes
+		return nil
+	})
+}
+
+func (h *harvester) saveBackfillLog(entry backfillLogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	key := backfillLogPrefix + entry.AppliedAt.Format(backfillLogTime)
+	return h.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(key), data)
+	})
+}
+
+// getBackfillLog returns up to limit applied changes, newest first.
+func getBackfillLog(db *badger.DB, limit int) ([]backfillLogEntry, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.Reverse = true
+
+	// Seek past every key with the prefix when iterating in reverse
+	prefix := []byte(backfillLogPrefix)
+	seek := append(append([]byte{}, prefix...), 0xFF)
+
+	entries := make([]backfillLogEntry, 0)
+	err := db.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+		for iter.Seek(seek); iter.ValidForPrefix(prefix) && len(entries) < limit; iter.Next() {
+			var entry backfillLogEntry
+			err := iter.Item().Value(func(v []byte) error {
+				return json.Unmarshal(v, &entry)
 			})
 			if err != nil {
 				return err
 			}
-		} else {
-			log.Printf(
-				"Adding %.2f for key %s on %s\n",
-				task.Duration.Hours(),
-				task.Key,
-				task.Day.Format("2006-01-02"),
-			)
-
-			hours := task.Duration.Hours()
-
-			ctx, c := context.WithTimeout(context.Background(), time.Minute)
-			defer c()
-			_, _, err := h.harvestClient.Timesheet.CreateTimeEntryViaDuration(ctx, &harvest.TimeEntryCreateViaDuration{
-				ProjectId: entry.Project.Id,
-				TaskId:    entry.Task.Id,
-				Hours:     &hours,
-				SpentDate: &harvest.Date{Time: task.Day},
-			})
-			if err != nil {
-				return err
+
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+
+	return entries, err
+}
+
+// purgeBackfillLog removes the applied changes older than days.
+func purgeBackfillLog(db *badger.DB, days int) error {
+	prefix := []byte(backfillLogPrefix)
+	until := []byte(backfillLogPrefix + time.Now().AddDate(0, 0, -days).Format(backfillLogTime))
+
+	var keys [][]byte
+	err := db.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.IteratorOptions{})
+		defer iter.Close()
+
+		// Log keys sort by time so the old ones come first
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			key := iter.Item().KeyCopy(nil)
+			if bytes.Compare(key, until) >= 0 {
+				break
 			}
+			keys = append(keys, key)
 		}
+		return nil
+	})
+	if err != nil || len(keys) == 0 {
+		return err
 	}
 
-	log.Println("harvest backfill complete")
-	return nil
+	batch := db.NewWriteBatch()
+	defer batch.Cancel()
+	for _, key := range keys {
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+	}
+	return batch.Flush()
 }
 
 // will return true if the two floats are within a certian percent of each other
 func hoursMatch(a, b float64) bool {
+	if b == 0 {
+		return a == 0
+	}
 	return (a/b) < 1.05 && (a/b) > .95
 }
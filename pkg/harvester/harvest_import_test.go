@@ -0,0 +1,274 @@
+package harvester
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeBackend records the entries created and updated by the sync.
+type fakeBackend struct {
+	created []fakeCreate
+	updated []fakeUpdate
+}
+
+type fakeCreate struct {
+	ProjectID int64
+	TaskID    int64
+	Day       time.Time
+	Hours     float64
+	Notes     string
+}
+
+type fakeUpdate struct {
+	EntryID int64
+	Hours   float64
+	Notes   string
+}
+
+func (b *fakeBackend) Name() string                      { return "Fake" }
+func (b *fakeBackend) URL() (*url.URL, error)            { return nil, nil }
+func (b *fakeBackend) Projects() (Projects, error)       { return nil, nil }
+func (b *fakeBackend) RunningEntries() ([]*Entry, error) { return nil, nil }
+
+func (b *fakeBackend) StartTimer(projectID, taskID int64, notes string) (*Entry, error) {
+	return &Entry{ProjectID: projectID, TaskID: taskID, Notes: notes, Running: true}, nil
+}
+
+func (b *fakeBackend) StopTimer(entryID int64) (*Entry, error) {
+	return &Entry{ID: entryID}, nil
+}
+
+func (b *fakeBackend) ListEntries(from time.Time) ([]*Entry, error) {
+	return nil, nil
+}
+
+func (b *fakeBackend) CreateEntry(projectID, taskID int64, day time.Time, hours float64, notes string) (*Entry, error) {
+	b.created = append(b.created, fakeCreate{projectID, taskID, day, hours, notes})
+	return &Entry{ProjectID: projectID, TaskID: taskID, Day: day, Hours: hours, Notes: notes}, nil
+}
+
+func (b *fakeBackend) UpdateEntry(entryID int64, hours float64, notes string) (*Entry, error) {
+	b.updated = append(b.updated, fakeUpdate{entryID, hours, notes})
+	return &Entry{ID: entryID, Hours: hours, Notes: notes}, nil
+}
+
+func (b *fakeBackend) UpdateNotes(entryID int64, notes string) (*Entry, error) {
+	return &Entry{ID: entryID, Notes: notes}, nil
+}
+
+func TestPlanSync(t *testing.T) {
+	day := time.Date(2020, 3, 2, 0, 0, 0, 0, time.Local)
+	from := day.AddDate(0, 0, -7)
+
+	projects := Projects{{
+		ID:    1,
+		Code:  "ACME",
+		Name:  "Acme",
+		Tasks: []ProjectTask{{ID: 10, Name: fallbackTaskName}, {ID: 11, Name: "Development"}, {ID: 12, Name: "Bugs"}},
+	}}
+	mapping := &TaskMapping{
+		Projects: map[int64]int64{1: 11},
+		Rules:    []TaskRule{{ProjectID: 1, IssueType: "Bug", TaskID: 12}},
+	}
+
+	stored := func(hours float64) StoredTimer {
+		return StoredTimer{
+			Key:      "ACME",
+			Day:      day,
+			Duration: time.Duration(hours * float64(time.Hour)),
+		}
+	}
+	entry := func(id int64, hours float64, running bool) *Entry {
+		return &Entry{ID: id, ProjectID: 1, Code: "ACME", TaskID: 11, Day: day, Hours: hours, Running: running}
+	}
+
+	withNotes := func(timer StoredTimer, notes, synced string) StoredTimer {
+		timer.Notes = notes
+		timer.SyncedNotes = synced
+		return timer
+	}
+	withEntryNotes := func(e *Entry, notes string) *Entry {
+		e.Notes = notes
+		return e
+	}
+
+	tests := []struct {
+		name        string
+		stored      StoredTimers
+		entries     []*Entry
+		uncommitted map[string]bool
+		issues      map[string]*Issue
+		changes     []backfillChange
+		created     []fakeCreate
+		updated     []fakeUpdate
+	}{
+		{
+			name:   "no remote entry creates one with the mapped task",
+			stored: StoredTimers{stored(2)},
+			changes: []backfillChange{{
+				Action:     backfillCreate,
+				Key:        "ACME",
+				Day:        day,
+				LocalHours: 2,
+				ProjectID:  1,
+				TaskID:     11,
+			}},
+			created: []fakeCreate{{ProjectID: 1, TaskID: 11, Day: day, Hours: 2}},
+		},
+		{
+			name:   "issue rules choose the task of new entries",
+			stored: StoredTimers{stored(2)},
+			issues: map[string]*Issue{"ACME": {Key: "ACME", Type: "Bug"}},
+			changes: []backfillChange{{
+				Action:     backfillCreate,
+				Key:        "ACME",
+				Day:        day,
+				LocalHours: 2,
+				ProjectID:  1,
+				TaskID:     12,
+			}},
+			created: []fakeCreate{{ProjectID: 1, TaskID: 12, Day: day, Hours: 2}},
+		},
+		{
+			name:    "different hours update the entry by the difference",
+			stored:  StoredTimers{stored(3)},
+			entries: []*Entry{entry(5, 1.5, false), entry(6, 0.5, false)},
+			changes: []backfillChange{{
+				Action:      backfillUpdate,
+				Key:         "ACME",
+				Day:         day,
+				LocalHours:  3,
+				RemoteHours: 2,
+				EntryID:     5,
+				EntryHours:  1.5,
+			}},
+			updated: []fakeUpdate{{EntryID: 5, Hours: 2.5}},
+		},
+		{
+			name:    "running remote entries are skipped",
+			stored:  StoredTimers{stored(3)},
+			entries: []*Entry{entry(5, 1, true)},
+			changes: []backfillChange{},
+		},
+		{
+			name:        "days of paused timers wait for the timer to stop",
+			entries:     []*Entry{entry(5, 1, false)},
+			uncommitted: map[string]bool{string(storedTimerKey("ACME", day)): true},
+			changes:     []backfillChange{},
+		},
+		{
+			name:    "notes edited locally are pushed to an entry without notes",
+			stored:  StoredTimers{withNotes(stored(2), "Reviewed", "")},
+			entries: []*Entry{entry(5, 2, false)},
+			changes: []backfillChange{{
+				Action:      backfillUpdate,
+				Key:         "ACME",
+				Day:         day,
+				LocalHours:  2,
+				RemoteHours: 2,
+				EntryID:     5,
+				EntryHours:  2,
+				Notes:       "Reviewed",
+			}},
+			updated: []fakeUpdate{{EntryID: 5, Hours: 2, Notes: "Reviewed"}},
+		},
+		{
+			name:    "synced notes are not pushed again",
+			stored:  StoredTimers{withNotes(stored(2), "ACME", "ACME")},
+			entries: []*Entry{withEntryNotes(entry(5, 2, false), "Typed in harvest")},
+			changes: []backfillChange{},
+		},
+		{
+			name:    "notes typed in harvest are not overwritten",
+			stored:  StoredTimers{withNotes(stored(2), "Reviewed", "")},
+			entries: []*Entry{withEntryNotes(entry(5, 2, false), "Typed in harvest")},
+			changes: []backfillChange{},
+		},
+		{
+			name:    "notes of any entry of the day count",
+			stored:  StoredTimers{withNotes(stored(2), "Reviewed", "")},
+			entries: []*Entry{entry(5, 1, false), withEntryNotes(entry(6, 1, false), "Reviewed")},
+			changes: []backfillChange{},
+		},
+		{
+			name:    "hours within tolerance need no change",
+			stored:  StoredTimers{stored(2)},
+			entries: []*Entry{entry(5, 2.05, false)},
+			changes: []backfillChange{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issueOf := func(key string) *Issue { return tt.issues[key] }
+			changes, err := planSync(tt.stored, tt.entries, projects, nil, mapping, issueOf, tt.uncommitted, from, syncLocal)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(changes, tt.changes) {
+				t.Fatalf("planned %+v, want %+v", changes, tt.changes)
+			}
+
+			backend := &fakeBackend{}
+			for _, change := range changes {
+				if err := pushBackfillChange(backend, change); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(backend.created, tt.created) {
+				t.Errorf("created %+v, want %+v", backend.created, tt.created)
+			}
+			if !reflect.DeepEqual(backend.updated, tt.updated) {
+				t.Errorf("updated %+v, want %+v", backend.updated, tt.updated)
+			}
+		})
+	}
+}
+
+func TestKeepResolutions(t *testing.T) {
+	day := time.Date(2020, 3, 2, 0, 0, 0, 0, time.Local)
+	conflict := func(key string, local, remote float64) backfillChange {
+		return backfillChange{Action: backfillConflict, Key: key, Day: day, LocalHours: local, RemoteHours: remote}
+	}
+
+	previous := []backfillChange{conflict("ACME", 3, 2), conflict("INIT", 1, 2), conflict("OPS", 4, 1)}
+	previous[0].resolve(syncLocal)
+	previous[1].resolve(syncRemote)
+
+	changes := keepResolutions(previous, []backfillChange{
+		conflict("ACME", 3, 2),
+		conflict("INIT", 1, 3),
+		conflict("OPS", 4, 1),
+	})
+
+	for i, want := range []string{backfillUpdate, backfillConflict, backfillConflict} {
+		if changes[i].Action != want {
+			t.Errorf("%s planned %s, want %s", changes[i].Key, changes[i].Action, want)
+		}
+	}
+}
+
+func TestPurgeBackfillLog(t *testing.T) {
+	h, _ := newTestHarvester(t)
+
+	recent := time.Now().AddDate(0, 0, -1)
+	for _, applied := range []time.Time{time.Now().AddDate(0, 0, -storedTimerDays-1), recent} {
+		if err := h.saveBackfillLog(backfillLogEntry{AppliedAt: applied}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := purgeBackfillLog(h.db, storedTimerDays); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := getBackfillLog(h.db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !entries[0].AppliedAt.Equal(recent) {
+		t.Errorf("kept %+v, want only the change applied at %s", entries, recent)
+	}
+}
@@ -2,15 +2,16 @@ package harvester
 
 import (
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"sort"
+	"sync"
 	"time"
 
-	jira "github.com/andygrunwald/go-jira"
 	"github.com/asticode/go-astilectron"
 	astiptr "github.com/asticode/go-astitools/ptr"
 	"github.com/brentahughes/harvester/pkg/assets"
@@ -19,26 +20,65 @@ import (
 )
 
 type harvester struct {
-	app           *astilectron.Astilectron
-	menu          *astilectron.Menu
-	mainWindow    *Window
-	Settings      *Settings `json:"settings"`
-	changeCh      chan bool
-	db            *badger.DB
-	jiraClient    *jira.Client
-	harvestClient *HarvestClient
-	harvestURL    *url.URL
-	Timers        TaskTimers `json:"timers"`
-	listener      net.Listener
-	debug         bool
+	// mu guards the timers, settings and sync state shared by the window,
+	// the tray, the api and the loop in Start. Handlers run holding it.
+	mu sync.Mutex
+
+	app         *astilectron.Astilectron
+	menu        *astilectron.Menu
+	mainWindow  *Window
+	Settings    *Settings `json:"settings"`
+	changeCh    chan bool
+	db          *badger.DB
+	secrets     *secretBox
+	trackers    []IssueTracker
+	backend     Backend
+	backendURL  *url.URL
+	Timers      TaskTimers `json:"timers"`
+	listener    net.Listener
+	rpcHandlers map[string]rpcHandler
+	recovery    []activeTimer
+	idle        *idlePeriod
+	idleSince   *time.Time
+	suspendedAt *time.Time
+	oauthMu     sync.Mutex
+	oauthLogins map[string]*oauthLogin
+	// pendingBackfill holds harvest changes waiting to be approved
+	pendingBackfill []backfillChange
+	apiToken        string
+	apiAddrPath     string
+	preloadPath     string
+	fixedPort       bool
+	debug           bool
 }
 
-func NewHarvester(db *badger.DB) (*harvester, error) {
-	ln, err := net.Listen("tcp", "127.0.0.1:0")
+// newHarvester builds a harvester around the database without any window,
+// tray, or listener so the same timer logic can be driven from the CLI.
+func newHarvester(db *badger.DB) (*harvester, error) {
+	h := &harvester{
+		db:       db,
+		Settings: &Settings{},
+		changeCh: make(chan bool, 1),
+		Timers:   TaskTimers{},
+	}
+
+	if err := h.init(); err != nil {
+		return nil, errors.WithMessage(err, "harvester init error")
+	}
+
+	return h, nil
+}
+
+// NewHarvester starts the app listening on listenAddr for the window assets
+// and the local api. Use port 0 to pick a random free port.
+func NewHarvester(db *badger.DB, listenAddr string) (*harvester, error) {
+	ln, err := net.Listen("tcp", listenAddr)
 	if err != nil {
 		return nil, err
 	}
-	go http.Serve(ln, http.FileServer(assets.AssetFile()))
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(assets.AssetFile()))
+	go http.Serve(ln, mux)
 
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -58,18 +98,34 @@ func NewHarvester(db *badger.DB) (*harvester, error) {
 		return nil, err
 	}
 
-	h := &harvester{
-		app:      app,
-		db:       db,
-		Settings: &Settings{},
-		changeCh: make(chan bool),
-		Timers:   TaskTimers{},
-		listener: ln,
+	h, err := newHarvester(db)
+	if err != nil {
+		return nil, err
+	}
+	h.app = app
+	h.listener = ln
+	// OAuth redirect urls have to stay the same, a random port changes them
+	if _, port, err := net.SplitHostPort(listenAddr); err == nil && port != "" && port != "0" {
+		h.fixedPort = true
+	}
+	h.apiToken, err = readAPIToken(harvesterDir + "/" + apiTokenFile)
+	if err != nil {
+		return nil, err
+	}
+	h.preloadPath, err = writePreload(harvesterDir)
+	if err != nil {
+		return nil, err
 	}
+	mux.Handle(apiPrefix, h.apiHandler())
+	mux.HandleFunc(oauthCallbackPath, h.oauthCallback)
 
-	if err := h.init(); err != nil {
-		return nil, errors.WithMessage(err, "harvester init error")
+	// The command line finds the api through this file while the app holds
+	// the database
+	h.apiAddrPath = harvesterDir + "/" + apiAddrFile
+	if err := ioutil.WriteFile(h.apiAddrPath, []byte(ln.Addr().String()), 0600); err != nil {
+		return nil, err
 	}
+	log.Printf("api listening on http://%s%s, token in %s/%s", ln.Addr().String(), apiPrefix, harvesterDir, apiTokenFile)
 
 	if err := h.app.Start(); err != nil {
 		return nil, err
@@ -91,57 +147,99 @@ func (h *harvester) Start() {
 	// Start the purger to keep the database small
 	go StartJiraPurger(h.db)
 
+	h.mu.Lock()
 	if err := h.Refresh(); err != nil {
-		h.sendErr(err)
+		h.sendError(err)
+	}
+
+	if err := h.backfill(); err != nil {
+		h.sendError(err)
 	}
 
-	if err := h.backfillHarvest(); err != nil {
-		h.sendErr(err)
+	if err := h.syncWorklogs(); err != nil {
+		h.sendError(err)
 	}
+	h.mu.Unlock()
+
+	heartbeat := time.NewTicker(10 * time.Second)
+	defer heartbeat.Stop()
+	backfill := time.NewTicker(time.Hour)
+	defer backfill.Stop()
+	refresh := time.NewTicker(defaultRefreshInterval)
+	defer refresh.Stop()
 
+	lastBeat := time.Now().UTC()
 	for {
 		select {
-		case <-time.After(10 * time.Second):
+		case <-heartbeat.C:
+			h.mu.Lock()
+			h.checkSleep(lastBeat)
+			lastBeat = time.Now().UTC()
+
 			h.sendTimers(false, false)
-		case <-time.After(time.Hour):
-			if err := h.backfillHarvest(); err != nil {
-				h.sendErr(err)
+			if err := h.heartbeat(); err != nil {
+				h.sendError(err)
+			}
+			h.mu.Unlock()
+		case <-backfill.C:
+			h.mu.Lock()
+			if err := h.backfill(); err != nil {
+				h.sendError(err)
 			}
-		case <-time.After(defaultRefreshInterval):
+			if err := h.syncWorklogs(); err != nil {
+				h.sendError(err)
+			}
+			h.mu.Unlock()
+		case <-refresh.C:
+			h.mu.Lock()
 			if err := h.Refresh(); err != nil {
-				h.sendErr(err)
+				h.sendError(err)
 			}
+			h.mu.Unlock()
 		case <-h.changeCh:
-			if err := h.Settings.Save(h.db); err != nil {
-				h.sendErr(err)
-				continue
+			h.mu.Lock()
+			if err := h.applySettings(&previousSettings); err != nil {
+				h.sendError(err)
 			}
+			h.mu.Unlock()
+		}
+	}
+}
 
-			// If the jira credentials changed get a new client
-			if h.Settings.Jira.URL != previousSettings.Jira.URL ||
-				h.Settings.Jira.User != previousSettings.Jira.User ||
-				h.Settings.Jira.Pass != previousSettings.Jira.Pass {
-				if err := h.getNewJiraClient(); err != nil {
-					h.sendErr(err)
-					continue
-				}
-			}
+// settingsChanged has the loop in Start save the settings and pick up any
+// new credentials. It never blocks so it can be called holding mu.
+func (h *harvester) settingsChanged() {
+	select {
+	case h.changeCh <- true:
+	default:
+		// A change is already waiting to be picked up
+	}
+}
 
-			// If the harvest credentials changed get a new client
-			if h.Settings.Harvest.User != previousSettings.Harvest.User ||
-				h.Settings.Harvest.Pass != previousSettings.Harvest.Pass {
-				if err := h.getNewHarvestClient(); err != nil {
-					h.sendErr(err)
-					continue
-				}
-			}
+// applySettings saves the settings and gets new clients for the credentials
+// that changed since previous.
+func (h *harvester) applySettings(previous *Settings) error {
+	h.Settings.trackWorklogsSince()
+	if err := h.Settings.Save(h.db, h.secrets); err != nil {
+		return err
+	}
 
-			previousSettings = *h.Settings
-			if err := h.Refresh(); err != nil {
-				h.sendErr(err)
-			}
+	// If the jira credentials changed get a new client
+	if h.Settings.Jira != previous.Jira && jiraConfigured(&h.Settings.Jira) {
+		if err := h.getNewJiraClient(); err != nil {
+			return err
+		}
+	}
+
+	// If the harvest credentials changed get a new client
+	if h.Settings.Harvest != previous.Harvest && harvestConfigured(&h.Settings.Harvest) {
+		if err := h.getNewHarvestClient(); err != nil {
+			return err
 		}
 	}
+
+	*previous = *h.Settings
+	return h.Refresh()
 }
 
 func (h *harvester) Refresh() error {
@@ -149,66 +247,128 @@ func (h *harvester) Refresh() error {
 		h.Timers = TaskTimers{}
 	}
 
-	// Add jira details to any timers
-	if h.jiraClient != nil {
-		issues, err := h.getUsersActiveIssues()
-		if err != nil {
-			return err
-		}
+	// Add issues to timers
+	issues, err := h.getActiveIssues()
+	if err != nil {
+		return err
+	}
 
-		// Add jiras to timers
-		for _, jira := range issues {
-			jiraIssue := jira
-			timer, err := h.Timers.GetByKey(jira.Key)
-			if err != nil && err == ErrTimerNotExists {
-				timer = &TaskTimer{
-					Key: jira.Key,
-				}
+	for _, issue := range issues {
+		timer, err := h.Timers.GetByKey(issue.TimerKey())
+		if err != nil && err == ErrTimerNotExists {
+			timer = &TaskTimer{
+				Key: issue.TimerKey(),
 			}
+		}
 
-			timer.Jira = &jiraIssue
-			h.replaceTask(timer)
+		timer.Issue = issue
+		h.replaceTask(timer)
+	}
+
+	// Add custom tasks to timers
+	customs, err := getCustomTasks(h.db)
+	if err != nil {
+		return err
+	}
+
+	for _, task := range customs {
+		timer, err := h.Timers.GetByKey(task.Key)
+		if err != nil && err == ErrTimerNotExists {
+			timer = &TaskTimer{
+				Key: task.Key,
+			}
 		}
+
+		timer.Custom = task
+		h.replaceTask(timer)
 	}
 
-	// Add harvest details to timers
-	if h.harvestClient != nil {
-		if h.harvestURL == nil {
-			company, err := h.harvestClient.getCompany()
+	// Add backend projects to timers
+	if h.backend != nil {
+		if h.backendURL == nil {
+			u, err := h.backend.URL()
 			if err != nil {
 				return err
 			}
-			u, _ := url.Parse(*company.BaseUri)
-			h.harvestURL = u
+			h.backendURL = u
+		}
+
+		included, err := h.getIncludedProjects()
+		if err != nil {
+			return err
 		}
+		projects := included.withCodes()
 
-		tasks, err := h.harvestClient.getUserProjects()
+		running, err := h.backend.RunningEntries()
 		if err != nil {
 			return err
 		}
 
-		// Add harvest projects to timers
-		for _, task := range tasks {
-			harvestTask := *task
-			timer, err := h.Timers.GetByKey(*task.Project.Code)
+		// Drop projects that are no longer included from idle timers
+		for _, timer := range h.Timers {
+			if timer.Project == nil || timer.Custom != nil || timer.Running {
+				continue
+			}
+			if _, err := projects.getByKey(timer.Project.Code); err != nil {
+				timer.Project = nil
+				timer.Entry = nil
+			}
+		}
+
+		for _, project := range projects {
+			timer, err := h.Timers.GetByKey(project.Code)
 			if err != nil && err == ErrTimerNotExists {
 				timer = &TaskTimer{
-					Key: *task.Project.Code,
+					Key: project.Code,
 				}
 			}
 
-			if h.jiraClient != nil && timer.Jira == nil {
-				jira, err := h.getJiraByKey(*task.Project.Code)
-				if err != nil || jira.Fields.Status.Name == "Done" {
+			// Projects of finished issues are left out
+			name, _ := splitKey(project.Code)
+			if _, err := h.getTracker(name); err == nil && timer.Issue == nil {
+				issue, err := h.getIssue(project.Code)
+				if err != nil || issue.Done {
 					continue
 				}
-				timer.Jira = jira
+				timer.Issue = issue
 			}
 
-			timer.Harvest = &harvestTask
+			timer.Project = project
+
+			// Pick up timers running remotely, even if started elsewhere
+			timer.Entry = nil
+			for _, entry := range running {
+				if entry.ProjectID == project.ID && customs.forEntry(entry) == nil {
+					timer.Entry = entry
+					break
+				}
+			}
 
 			h.replaceTask(timer)
 		}
+
+		// Custom tasks log time to the project and task they were given
+		for _, task := range customs {
+			timer, err := h.Timers.GetByKey(task.Key)
+			if err != nil {
+				continue
+			}
+
+			timer.Project = nil
+			for _, project := range included {
+				if project.ID == task.ProjectID {
+					timer.Project = project
+				}
+			}
+
+			timer.Entry = nil
+			for _, entry := range running {
+				if customs.forEntry(entry) == task {
+					timer.Entry = entry
+					break
+				}
+			}
+		}
 	}
 
 	sort.SliceStable(h.Timers, func(a, b int) bool {
@@ -223,7 +383,47 @@ func (h *harvester) Refresh() error {
 }
 
 func (h *harvester) init() error {
-	settings, err := GetSettings(h.db)
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	// Finish a rewrite of the database harvester stopped in the middle of
+	if err := restoreDatabase(h.db, home+"/.harvester/"+rewriteBackupFile); err != nil {
+		return err
+	}
+
+	// Pick up any timers left running by another harvester process
+	activeTimers, err := getActiveTimers(h.db)
+	if err != nil {
+		return err
+	}
+	for _, timer := range activeTimers {
+		h.replaceTask(timer.taskTimer())
+
+		// Paused timers lost no time and timers started from the command line
+		// have no heartbeat, both are picked up as they were
+		if !timer.Paused && !timer.Detached {
+			h.recovery = append(h.recovery, timer)
+		}
+	}
+
+	// The key is kept away from the database so copying the harvester
+	// directory does not copy both
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return err
+	}
+
+	h.secrets, err = newSecretBox(h.db, os.Getenv(passphraseEnv), configDir+"/harvester/secret.key", home+"/.harvester/secret.key")
+	if err != nil {
+		return err
+	}
+
+	settings, err := GetSettings(h.db, h.secrets)
+	if err == ErrSecretDecrypt {
+		return err
+	}
 	if err != nil && err != badger.ErrKeyNotFound {
 		log.Println(err)
 	}
@@ -234,15 +434,28 @@ func (h *harvester) init() error {
 
 	h.Settings = settings
 
+	// Encrypt any credentials saved before encryption was supported
+	if h.Settings.plaintext {
+		log.Println("encrypting stored credentials")
+		if err := h.Settings.Save(h.db, h.secrets); err != nil {
+			return err
+		}
+
+		// Saving leaves the plaintext in earlier versions of the settings
+		if err := rewriteDatabase(h.db, home+"/.harvester/"+rewriteBackupFile); err != nil {
+			return err
+		}
+	}
+
 	// Setup the jira client
-	if h.Settings.Jira.URL != "" && h.Settings.Jira.User != "" {
+	if jiraConfigured(&h.Settings.Jira) {
 		if err := h.getNewJiraClient(); err != nil {
 			return err
 		}
 	}
 
 	// Setup the harvest client
-	if h.Settings.Harvest.User != "" && h.Settings.Harvest.Pass != "" {
+	if harvestConfigured(&h.Settings.Harvest) {
 		if err := h.getNewHarvestClient(); err != nil {
 			return err
 		}
@@ -251,8 +464,12 @@ func (h *harvester) init() error {
 	return nil
 }
 
+// stopAllTimers stops the running timers, paused timers stay paused.
 func (h *harvester) stopAllTimers() error {
 	for _, timer := range h.Timers {
+		if timer.Paused {
+			continue
+		}
 		if err := h.StopTimer(timer); err != nil {
 			return err
 		}
@@ -261,7 +478,10 @@ func (h *harvester) stopAllTimers() error {
 }
 
 func (h *harvester) Stop() {
-	if err := h.Settings.Save(h.db); err != nil {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.Settings.Save(h.db, h.secrets); err != nil {
 		log.Fatal(err)
 	}
 
@@ -269,6 +489,7 @@ func (h *harvester) Stop() {
 		log.Fatal(err)
 	}
 
+	os.Remove(h.apiAddrPath)
 	h.listener.Close()
 	h.mainWindow.Close()
 	h.app.Close()
@@ -321,6 +542,9 @@ func (h *harvester) createMenu(trayIcon string) {
 		{
 			Label: astiptr.Str("Stop Timers"),
 			OnClick: func(e astilectron.Event) (deleteListener bool) {
+				h.mu.Lock()
+				defer h.mu.Unlock()
+
 				h.stopAllTimers()
 				h.sendTimers(false, false)
 				return
@@ -0,0 +1,288 @@
+package harvester
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	idleKeep     = "keep"
+	idleDiscard  = "discard"
+	idleReassign = "reassign"
+
+	// minIdlePeriod is the shortest idle period worth asking about
+	minIdlePeriod = time.Minute
+
+	// sleepGap is how late a heartbeat can be before the machine is assumed
+	// to have been asleep
+	sleepGap = 2 * time.Minute
+)
+
+// idlePeriod is time the timers of Keys kept running while the user was
+// away, timers started during the period are idle from their start.
+type idlePeriod struct {
+	Keys  []string  `json:"keys"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+type idleReport struct {
+	IdleSeconds int `json:"idleSeconds"`
+}
+
+type idleResolveRequest struct {
+	Action string `json:"action"`
+	Key    string `json:"key"`
+}
+
+func (h *harvester) registerIdleHandlers() {
+	// Reported by the window from electron's powerMonitor
+	h.registerRPC("system.idle", func(payload json.RawMessage) (interface{}, error) {
+		var req idleReport
+		if err := decodePayload(payload, &req); err != nil {
+			return nil, err
+		}
+
+		h.systemIdle(time.Duration(req.IdleSeconds) * time.Second)
+		return nil, nil
+	})
+
+	h.registerRPC("system.suspend", func(json.RawMessage) (interface{}, error) {
+		suspendedAt := time.Now().UTC()
+		h.suspendedAt = &suspendedAt
+		return nil, nil
+	})
+
+	h.registerRPC("system.resume", func(json.RawMessage) (interface{}, error) {
+		if h.suspendedAt != nil {
+			h.idleDetected(*h.suspendedAt, time.Now().UTC())
+			h.suspendedAt = nil
+		}
+		return nil, nil
+	})
+
+	h.registerRPC("idle.resolve", func(payload json.RawMessage) (interface{}, error) {
+		var req idleResolveRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return nil, err
+		}
+
+		if err := h.resolveIdle(req.Action, req.Key); err != nil {
+			return nil, err
+		}
+		return nil, h.renderMainWindow()
+	})
+}
+
+// systemIdle tracks how long the system has been idle. Once the user returns
+// after being idle for longer than the configured threshold they are asked
+// what to do with the time.
+func (h *harvester) systemIdle(idle time.Duration) {
+	if h.Settings.IdleMinutes <= 0 {
+		return
+	}
+	threshold := time.Duration(h.Settings.IdleMinutes) * time.Minute
+
+	idleSince := time.Now().UTC().Add(-idle)
+	if idle >= threshold {
+		if h.idleSince == nil {
+			h.idleSince = &idleSince
+		}
+		return
+	}
+
+	if h.idleSince != nil {
+		h.idleDetected(*h.idleSince, idleSince)
+		h.idleSince = nil
+	}
+}
+
+// checkSleep compares the wall clock against the last heartbeat to catch the
+// machine sleeping when the window did not report it.
+func (h *harvester) checkSleep(lastBeat time.Time) {
+	current := time.Now().UTC().Round(0)
+	if current.Sub(lastBeat.Round(0)) > sleepGap {
+		h.idleDetected(lastBeat, current)
+	}
+}
+
+// idleDetected records the idle period against every running timer and asks
+// the user what to do with it. Overlapping reports are merged into one
+// period.
+func (h *harvester) idleDetected(start, end time.Time) {
+	var keys []string
+	earliest := end
+	for _, timer := range h.Timers {
+		if !timer.Running {
+			continue
+		}
+
+		timerStart := start
+		if timerStart.Before(*timer.StartedAt) {
+			timerStart = *timer.StartedAt
+		}
+		if end.Sub(timerStart) < minIdlePeriod {
+			continue
+		}
+
+		keys = append(keys, timer.Key)
+		if timerStart.Before(earliest) {
+			earliest = timerStart
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	start = earliest
+
+	if h.idle != nil {
+		if h.idle.Start.Before(start) {
+			start = h.idle.Start
+		}
+		if h.idle.End.After(end) {
+			end = h.idle.End
+		}
+		for _, key := range h.idle.Keys {
+			if !containsString(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	h.idle = &idlePeriod{
+		Keys:  keys,
+		Start: start.UTC(),
+		End:   end.UTC(),
+	}
+
+	if h.mainWindow != nil {
+		if err := h.renderIdle(); err != nil {
+			h.sendError(err)
+		}
+	}
+}
+
+// resolveIdle keeps the idle time on the running timers, discards it, or
+// moves it to the timer for reassignKey. The running timers are restarted
+// from the end of the idle period and their harvest entries are adjusted to
+// match.
+func (h *harvester) resolveIdle(action, reassignKey string) error {
+	if h.idle == nil {
+		return nil
+	}
+
+	idle := *h.idle
+	switch action {
+	case idleKeep:
+		h.idle = nil
+		return nil
+	case idleDiscard:
+	case idleReassign:
+		if reassignKey == "" || containsString(idle.Keys, reassignKey) {
+			return &rpcError{Code: rpcErrBadRequest, Message: "choose another timer to move the idle time to"}
+		}
+	default:
+		return &rpcError{Code: rpcErrBadRequest, Message: fmt.Sprintf("unknown idle action %s", action)}
+	}
+
+	var removed bool
+	for _, key := range idle.Keys {
+		timer, err := h.Timers.GetByKey(key)
+		if err != nil || !timer.Running {
+			continue
+		}
+
+		if err := h.removeIdleTime(timer, idle); err != nil {
+			return err
+		}
+		removed = true
+	}
+	if !removed {
+		h.idle = nil
+		return fmt.Errorf("timers for %s are no longer running", strings.Join(idle.Keys, ", "))
+	}
+
+	if action == idleReassign {
+		reassigned := TimeInterval{
+			Start:  idle.Start,
+			End:    idle.End,
+			Source: intervalSourceIdle,
+		}
+		if err := h.storeInterval(reassignKey, reassigned, ""); err != nil {
+			return err
+		}
+	}
+
+	h.idle = nil
+	return nil
+}
+
+// removeIdleTime keeps the time the timer ran before going idle and restarts
+// it from when the user returned.
+func (h *harvester) removeIdleTime(timer *TaskTimer, idle idlePeriod) error {
+	idleStart := idle.Start
+	if idleStart.Before(*timer.StartedAt) {
+		idleStart = *timer.StartedAt
+	}
+	if !idle.End.After(idleStart) {
+		return nil
+	}
+
+	worked := TimeInterval{
+		Start:  *timer.StartedAt,
+		End:    idleStart,
+		Source: intervalSourceTimer,
+	}
+	if worked.Duration() > 0 {
+		timer.Pending = append(timer.Pending, worked)
+	}
+
+	if timer.Entry != nil {
+		entry, err := h.removeRemoteTime(timer.Entry, idle.End.Sub(idleStart))
+		if err != nil {
+			return err
+		}
+		timer.Entry = entry
+	}
+
+	startedAt := idle.End
+	timer.StartedAt = &startedAt
+	return h.saveActiveTimer(timer)
+}
+
+// removeRemoteTime takes the duration off the running backend timer by
+// stopping it, lowering its hours and starting it again.
+func (h *harvester) removeRemoteTime(entry *Entry, d time.Duration) (*Entry, error) {
+	stopped, err := h.trimRemoteTime(entry.ID, d)
+	if err != nil {
+		return nil, err
+	}
+
+	if restarter, ok := h.backend.(timerRestarter); ok {
+		return restarter.RestartTimer(stopped.ID)
+	}
+	return h.backend.StartTimer(stopped.ProjectID, stopped.TaskID, stopped.Notes)
+}
+
+// trimRemoteTime stops the running backend timer of the entry and takes the
+// duration off its hours.
+func (h *harvester) trimRemoteTime(entryID int64, d time.Duration) (*Entry, error) {
+	if h.backend == nil {
+		return nil, errors.New("no time tracking backend configured")
+	}
+
+	stopped, err := h.backend.StopTimer(entryID)
+	if err != nil {
+		return nil, err
+	}
+
+	stopped.Hours = math.Max(0, stopped.Hours-d.Hours())
+	if _, err := h.backend.UpdateEntry(stopped.ID, stopped.Hours, ""); err != nil {
+		return nil, err
+	}
+	return stopped, nil
+}
@@ -0,0 +1,216 @@
+package harvester
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/jinzhu/now"
+)
+
+const (
+	intervalSourceTimer   = "timer"
+	intervalSourceManual  = "manual"
+	intervalSourceLegacy  = "legacy"
+	intervalSourceRunning = "running"
+	intervalSourceIdle    = "idle"
+)
+
+// TimeInterval is a single block of work tracked against a key.
+type TimeInterval struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Source string    `json:"source"`
+	Notes  string    `json:"notes,omitempty"`
+}
+
+func (i TimeInterval) Duration() time.Duration {
+	return i.End.Sub(i.Start)
+}
+
+// UnmarshalJSON converts timers stored before intervals were tracked into a
+// single legacy interval covering the stored duration.
+func (t *StoredTimer) UnmarshalJSON(data []byte) error {
+	type storedTimer StoredTimer
+	var timer storedTimer
+	if err := json.Unmarshal(data, &timer); err != nil {
+		return err
+	}
+
+	*t = StoredTimer(timer)
+	if len(t.Intervals) == 0 && t.Duration > 0 {
+		t.Intervals = []TimeInterval{
+			{
+				Start:  t.Day,
+				End:    t.Day.Add(t.Duration),
+				Source: intervalSourceLegacy,
+			},
+		}
+	}
+
+	return nil
+}
+
+func (t *StoredTimer) addInterval(interval TimeInterval) {
+	t.Intervals = append(t.Intervals, interval)
+	t.sortIntervals()
+	t.Duration = t.total()
+}
+
+func (t *StoredTimer) total() time.Duration {
+	var total time.Duration
+	for _, interval := range t.Intervals {
+		total += interval.Duration()
+	}
+	return total
+}
+
+func (t *StoredTimer) sortIntervals() {
+	sort.SliceStable(t.Intervals, func(a, b int) bool {
+		return t.Intervals[a].Start.Before(t.Intervals[b].Start)
+	})
+}
+
+// resize grows or shrinks the intervals so they add up to duration. Extra time
+// is added as an interval from source after the last one and missing time is
+// taken from the most recent intervals first.
+func (t *StoredTimer) resize(duration time.Duration, source string) {
+	diff := duration - t.total()
+
+	switch {
+	case diff > 0:
+		start := now.With(t.Day).BeginningOfDay()
+		if len(t.Intervals) > 0 {
+			start = t.Intervals[len(t.Intervals)-1].End
+		}
+
+		// Keep the new interval within the day
+		if dayEnd := now.With(t.Day).EndOfDay(); start.Add(diff).After(dayEnd) {
+			start = dayEnd.Add(-diff)
+		}
+
+		t.addInterval(TimeInterval{
+			Start:  start,
+			End:    start.Add(diff),
+			Source: source,
+		})
+	case diff < 0:
+		remove := -diff
+		for i := len(t.Intervals) - 1; i >= 0 && remove > 0; i-- {
+			length := t.Intervals[i].Duration()
+			if length <= remove {
+				t.Intervals = t.Intervals[:i]
+				remove -= length
+				continue
+			}
+
+			t.Intervals[i].End = t.Intervals[i].End.Add(-remove)
+			remove = 0
+		}
+		t.Duration = t.total()
+	}
+}
+
+// splitAtMidnight breaks the interval into parts that each fall within a
+// single local day.
+func splitAtMidnight(interval TimeInterval) []TimeInterval {
+	var parts []TimeInterval
+	for {
+		midnight := now.With(interval.Start.Local()).EndOfDay().Add(time.Nanosecond)
+		if !interval.End.After(midnight) {
+			return append(parts, interval)
+		}
+
+		part := interval
+		part.End = midnight.UTC()
+		parts = append(parts, part)
+
+		interval.Start = midnight.UTC()
+	}
+}
+
+// TimelineEntry is an interval on the day timeline flagged when it overlaps
+// with the time tracked against another key.
+type TimelineEntry struct {
+	Key string `json:"key"`
+	TimeInterval
+	Overlaps bool `json:"overlaps"`
+}
+
+type timelineRequest struct {
+	Day string `json:"day"`
+}
+
+func (h *harvester) registerTimelineHandlers() {
+	h.registerRPC("timesheet.timeline", func(payload json.RawMessage) (interface{}, error) {
+		var req timelineRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return nil, err
+		}
+
+		day, err := time.ParseInLocation("2006-01-02", req.Day, time.Local)
+		if err != nil {
+			return nil, &rpcError{Code: rpcErrBadRequest, Message: "invalid day " + req.Day}
+		}
+
+		return h.getDayTimeline(day)
+	})
+}
+
+// getDayTimeline returns every interval tracked on day, including running
+// timers, in the order they started.
+func (h *harvester) getDayTimeline(day time.Time) ([]TimelineEntry, error) {
+	start := now.With(day).BeginningOfDay()
+	end := now.With(day).EndOfDay()
+
+	timers, err := getTimersByOpts(h.db, badger.DefaultIteratorOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]TimelineEntry, 0)
+	for _, timer := range timers {
+		for _, interval := range timer.Intervals {
+			if interval.End.Before(start) || interval.Start.After(end) {
+				continue
+			}
+			entries = append(entries, TimelineEntry{Key: timer.Key, TimeInterval: interval})
+		}
+	}
+
+	for _, timer := range h.Timers {
+		for _, running := range timer.uncommitted(time.Now().UTC()) {
+			for _, part := range splitAtMidnight(running) {
+				if part.End.Before(start) || part.Start.After(end) {
+					continue
+				}
+				entries = append(entries, TimelineEntry{Key: timer.Key, TimeInterval: part})
+			}
+		}
+	}
+
+	sort.SliceStable(entries, func(a, b int) bool {
+		return entries[a].Start.Before(entries[b].Start)
+	})
+
+	markOverlaps(entries)
+	return entries, nil
+}
+
+// markOverlaps flags entries of different keys whose intervals overlap.
+// Entries must be sorted by their start time.
+func markOverlaps(entries []TimelineEntry) {
+	for i := range entries {
+		for j := i + 1; j < len(entries); j++ {
+			if !entries[j].Start.Before(entries[i].End) {
+				break
+			}
+			if entries[j].Key == entries[i].Key {
+				continue
+			}
+			entries[i].Overlaps = true
+			entries[j].Overlaps = true
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package harvester
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultTracker is the tracker whose issues are keyed without a prefix, so
+// timers tracked before other trackers existed and harvest project codes still
+// match its issue keys.
+const defaultTracker = "jira"
+
+// IssueTracker is a source of issues to track time against.
+type IssueTracker interface {
+	// Name identifies the tracker and qualifies the keys of its issues
+	Name() string
+
+	// ActiveIssues lists the issues the user is working on
+	ActiveIssues() ([]*Issue, error)
+
+	// Issue looks up a single issue by its key within the tracker
+	Issue(key string) (*Issue, error)
+
+	// IssueURL is the web page of the issue with the key
+	IssueURL(key string) string
+}
+
+// Issue is an issue of a tracker. Key is the key within the tracker, timers
+// use the qualified key returned by TimerKey.
+type Issue struct {
+	Tracker string   `json:"tracker"`
+	Key     string   `json:"key"`
+	Summary string   `json:"summary"`
+	Status  string   `json:"status"`
+	Type    string   `json:"type"`
+	Labels  []string `json:"labels"`
+	Done    bool     `json:"done"`
+	URL     string   `json:"url"`
+
+	// Epic is the key of the epic the issue belongs to
+	Epic string `json:"epic,omitempty"`
+
+	// Query is the name of the query that found the issue
+	Query string `json:"query"`
+}
+
+// TimerKey is the key of the timer tracking time against the issue.
+func (i *Issue) TimerKey() string {
+	return qualifyKey(i.Tracker, i.Key)
+}
+
+func qualifyKey(tracker, key string) string {
+	if tracker == defaultTracker {
+		return key
+	}
+	return tracker + ":" + key
+}
+
+// splitKey returns the tracker and the key within the tracker of a timer key.
+func splitKey(timerKey string) (string, string) {
+	if i := strings.Index(timerKey, ":"); i > 0 {
+		return timerKey[:i], timerKey[i+1:]
+	}
+	return defaultTracker, timerKey
+}
+
+// setTracker adds the tracker, replacing any tracker with the same name.
+func (h *harvester) setTracker(tracker IssueTracker) {
+	for i, t := range h.trackers {
+		if t.Name() == tracker.Name() {
+			h.trackers[i] = tracker
+			return
+		}
+	}
+	h.trackers = append(h.trackers, tracker)
+}
+
+func (h *harvester) getTracker(name string) (IssueTracker, error) {
+	for _, t := range h.trackers {
+		if t.Name() == name {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("no issue tracker %s configured", name)
+}
+
+// getActiveIssues returns the active issues of every tracker.
+func (h *harvester) getActiveIssues() ([]*Issue, error) {
+	var issues []*Issue
+	for _, tracker := range h.trackers {
+		found, err := tracker.ActiveIssues()
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, found...)
+	}
+	return issues, nil
+}
+
+// getIssue looks up the issue of a timer key with its tracker.
+func (h *harvester) getIssue(timerKey string) (*Issue, error) {
+	name, key := splitKey(timerKey)
+	tracker, err := h.getTracker(name)
+	if err != nil {
+		return nil, err
+	}
+	return tracker.Issue(key)
+}
+
+// issueURL is the web page of the issue of a timer key.
+func (h *harvester) issueURL(timerKey string) (string, error) {
+	name, key := splitKey(timerKey)
+	tracker, err := h.getTracker(name)
+	if err != nil {
+		return "", err
+	}
+	return tracker.IssueURL(key), nil
+}
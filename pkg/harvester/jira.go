@@ -1,51 +1,414 @@
 package harvester
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"regexp"
+	"strings"
 	"time"
 
 	jira "github.com/andygrunwald/go-jira"
+	"golang.org/x/oauth2"
 )
 
-const issueQuery = `assignee = currentUser() AND Resolution = Unresolved AND status not in ("To Do", "Selected")`
+const (
+	defaultQueryName  = "Active"
+	defaultIssueQuery = `assignee = currentUser() AND Resolution = Unresolved AND status not in ("To Do", "Selected")`
+
+	// jiraWorklogTime is the only timestamp format jira accepts for worklogs
+	jiraWorklogTime = "2006-01-02T15:04:05.000-0700"
+
+	// Ways of authenticating with jira, basic is used when none is set
+	jiraAuthBasic = "basic"
+	jiraAuthToken = "token"
+	jiraAuthPAT   = "pat"
+	jiraAuthOAuth = "oauth"
+	// OAuth 1.0a through an application link of jira server or data center
+	jiraAuthOAuth1 = "oauth1"
+
+	// Atlassian cloud sites are reached through the api gateway with oauth
+	atlassianResourcesURL = "https://api.atlassian.com/oauth/token/accessible-resources"
+	atlassianAPIURL       = "https://api.atlassian.com/ex/jira/"
+)
+
+var atlassianEndpoint = oauth2.Endpoint{
+	AuthURL:  "https://auth.atlassian.com/authorize",
+	TokenURL: "https://auth.atlassian.com/oauth/token",
+}
+
+// jiraKeyPattern matches issue keys, other keys are harvest project codes
+var jiraKeyPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*-[0-9]+$`)
+
+// JiraQuery is a named JQL search used to find issues to track time against.
+type JiraQuery struct {
+	Name string `json:"name"`
+	JQL  string `json:"jql"`
+}
+
+// jiraTracker finds issues with the queries in the settings.
+type jiraTracker struct {
+	client   *jira.Client
+	settings *Settings
+}
+
+// jiraUser is the user jira authenticated, reported when testing settings.
+type jiraUser struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// atlassianSite is a cloud site the oauth token gives access to.
+type atlassianSite struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+func (h *harvester) registerJiraHandlers() {
+	// Tests the settings in the form before they are saved
+	h.registerRPC("jira.test", func(payload json.RawMessage) (interface{}, error) {
+		var data SettingsData
+		if err := decodePayload(payload, &data); err != nil {
+			return nil, err
+		}
+
+		// The oauth token is only known once logged in
+		settings := &data
+		if data.Auth == jiraAuthOAuth || data.Auth == jiraAuthOAuth1 {
+			settings = &h.Settings.Jira
+		}
+
+		client, err := h.newJiraClient(settings)
+		if err != nil {
+			return nil, err
+		}
+
+		user, _, err := client.User.GetSelf()
+		if err != nil {
+			return nil, &rpcError{Code: rpcErrBadRequest, Message: "unable to connect to jira: " + err.Error()}
+		}
+
+		return jiraUser{
+			Name:  user.DisplayName,
+			Email: user.EmailAddress,
+		}, nil
+	})
+
+	h.registerRPC("jira.login", func(payload json.RawMessage) (interface{}, error) {
+		var data SettingsData
+		if err := decodePayload(payload, &data); err != nil {
+			return nil, err
+		}
+		if data.Auth == jiraAuthOAuth1 {
+			return nil, h.startJiraOAuth1(data)
+		}
+		if data.ClientID == "" || data.ClientSecret == "" {
+			return nil, &rpcError{Code: rpcErrBadRequest, Message: "client id and secret are required"}
+		}
+
+		config := jiraOAuthConfig(&data)
+		return nil, h.startOAuth(config, func(token *oauth2.Token) error {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			site, err := getAtlassianSite(config.Client(ctx, token), data.URL)
+			if err != nil {
+				return err
+			}
+
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			jiraSettings := h.Settings.Jira
+			jiraSettings.Auth = jiraAuthOAuth
+			jiraSettings.ClientID = data.ClientID
+			jiraSettings.ClientSecret = data.ClientSecret
+			jiraSettings.URL = site.URL
+			jiraSettings.APIURL = atlassianAPIURL + site.ID
+			if err := jiraSettings.setOAuthToken(token); err != nil {
+				return err
+			}
+
+			h.Settings.Jira = jiraSettings
+			h.settingsChanged()
+			return nil
+		}, oauth2.SetAuthURLParam("audience", "api.atlassian.com"), oauth2.SetAuthURLParam("prompt", "consent"))
+	})
+}
+
+// startJiraOAuth1 logs in through the application link of a jira server
+// with the consumer key and private key in data.
+func (h *harvester) startJiraOAuth1(data SettingsData) error {
+	config, err := jiraOAuth1Config(&data)
+	if err != nil {
+		return err
+	}
+
+	return h.startOAuth1(config, func(token *oauth1Token) error {
+		raw, err := json.Marshal(token)
+		if err != nil {
+			return err
+		}
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		jiraSettings := h.Settings.Jira
+		jiraSettings.Auth = jiraAuthOAuth1
+		jiraSettings.URL = data.URL
+		jiraSettings.ClientID = data.ClientID
+		jiraSettings.ClientSecret = data.ClientSecret
+		jiraSettings.APIURL = ""
+		jiraSettings.Token = string(raw)
+
+		h.Settings.Jira = jiraSettings
+		h.settingsChanged()
+		return nil
+	})
+}
 
 func (h *harvester) getNewJiraClient() error {
-	tp := jira.BasicAuthTransport{
-		Username: h.Settings.Jira.User,
-		Password: h.Settings.Jira.Pass,
-		Transport: &http.Transport{DialContext: (&net.Dialer{
-			Timeout: 10 * time.Second,
-		}).DialContext,
-		},
+	client, err := h.newJiraClient(&h.Settings.Jira)
+	if err != nil {
+		return err
 	}
 
-	var err error
-	h.jiraClient, err = jira.NewClient(tp.Client(), h.Settings.Jira.URL)
-	return err
+	h.setTracker(&jiraTracker{
+		client:   client,
+		settings: h.Settings,
+	})
+	return nil
+}
+
+// newJiraClient builds a client authenticating the way set in data.
+func (h *harvester) newJiraClient(data *SettingsData) (*jira.Client, error) {
+	transport := &http.Transport{DialContext: (&net.Dialer{
+		Timeout: 10 * time.Second,
+	}).DialContext,
+	}
+
+	var client *http.Client
+	baseURL := data.URL
+	switch data.Auth {
+	case jiraAuthPAT:
+		// Personal access tokens of jira server are sent as bearer tokens
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: transport})
+		client = oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{
+			AccessToken: data.Pass,
+		}))
+	case jiraAuthOAuth:
+		var err error
+		client, err = h.oauthClient(jiraOAuthConfig(data), data, transport)
+		if err != nil {
+			return nil, err
+		}
+		baseURL = data.APIURL
+	case jiraAuthOAuth1:
+		config, err := jiraOAuth1Config(data)
+		if err != nil {
+			return nil, err
+		}
+		token, err := oauth1TokenOf(data)
+		if err != nil {
+			return nil, err
+		}
+		if token == nil {
+			return nil, errNotLoggedIn
+		}
+		client = &http.Client{Transport: &oauth1Transport{
+			config: config,
+			token:  token.Token,
+			base:   transport,
+		}}
+	default:
+		// Cloud api tokens are sent in place of the password with the email
+		tp := jira.BasicAuthTransport{
+			Username:  data.User,
+			Password:  data.Pass,
+			Transport: transport,
+		}
+		client = tp.Client()
+	}
+
+	return jira.NewClient(client, baseURL)
+}
+
+// jiraConfigured reports if data has what the auth needs to connect.
+func jiraConfigured(data *SettingsData) bool {
+	switch data.Auth {
+	case jiraAuthPAT:
+		return data.URL != "" && data.Pass != ""
+	case jiraAuthOAuth:
+		return data.Token != "" && data.APIURL != ""
+	case jiraAuthOAuth1:
+		return data.URL != "" && data.Token != ""
+	}
+	return data.URL != "" && data.User != ""
 }
 
-func (h *harvester) getUsersActiveIssues() ([]jira.Issue, error) {
-	issues, _, err := h.jiraClient.Issue.Search(issueQuery, nil)
+func jiraOAuthConfig(data *SettingsData) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     data.ClientID,
+		ClientSecret: data.ClientSecret,
+		Endpoint:     atlassianEndpoint,
+		Scopes:       []string{"read:jira-user", "read:jira-work", "write:jira-work", "offline_access"},
+	}
+}
+
+// getAtlassianSite returns the site of siteURL the token gives access to, or
+// the only site when siteURL is not set.
+func getAtlassianSite(client *http.Client, siteURL string) (*atlassianSite, error) {
+	resp, err := client.Get(atlassianResourcesURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to list jira sites: %s", resp.Status)
+	}
+
+	var sites []atlassianSite
+	if err := json.NewDecoder(resp.Body).Decode(&sites); err != nil {
+		return nil, err
+	}
+
+	for i, site := range sites {
+		if strings.TrimSuffix(site.URL, "/") == strings.TrimSuffix(siteURL, "/") {
+			return &sites[i], nil
+		}
+	}
+	if siteURL == "" && len(sites) == 1 {
+		return &sites[0], nil
+	}
+	if siteURL == "" {
+		return nil, errors.New("the login has access to several jira sites, set the url of the one to use")
+	}
+	return nil, fmt.Errorf("the login has no access to %s", siteURL)
+}
+
+func (t *jiraTracker) Name() string {
+	return "jira"
+}
+
+// ActiveIssues runs every configured query returning each issue once,
+// attributed to the first query that found it.
+func (t *jiraTracker) ActiveIssues() ([]*Issue, error) {
+	seen := make(map[string]bool)
+
+	var issues []*Issue
+	for _, query := range t.settings.jiraQueries() {
+		found, _, err := t.client.Issue.Search(query.JQL, nil)
+		if err != nil {
+			log.Print(err)
+			return nil, fmt.Errorf("error getting jira issues for query %s", query.Name)
+		}
+
+		for i := range found {
+			if seen[found[i].Key] {
+				continue
+			}
+			seen[found[i].Key] = true
+
+			issue := t.issue(&found[i])
+			issue.Query = query.Name
+			issues = append(issues, issue)
+		}
+	}
+	return issues, nil
+}
+
+func (t *jiraTracker) Issue(key string) (*Issue, error) {
+	issue, _, err := t.client.Issue.Get(key, nil)
 	if err != nil {
-		log.Print(err)
-		return nil, errors.New("error getting active jira issues")
+		return nil, err
 	}
-	return issues, err
+	return t.issue(issue), nil
 }
 
-func (h *harvester) getJiraByKey(key string) (*jira.Issue, error) {
-	issue, _, err := h.jiraClient.Issue.Get(key, nil)
-	return issue, err
+func (t *jiraTracker) IssueURL(key string) string {
+	return strings.TrimSuffix(t.settings.Jira.URL, "/") + "/browse/" + key
 }
 
-func (h *harvester) harvestToJira(task *harvestTask) jira.Issue {
-	return jira.Issue{
-		Key: *task.Project.Code,
-		Fields: &jira.IssueFields{
-			Summary: *task.Project.Name,
-		},
+func (t *jiraTracker) issue(i *jira.Issue) *Issue {
+	issue := &Issue{
+		Tracker: t.Name(),
+		Key:     i.Key,
+		URL:     t.IssueURL(i.Key),
+	}
+	if i.Fields == nil {
+		return issue
+	}
+
+	issue.Summary = i.Fields.Summary
+	issue.Type = i.Fields.Type.Name
+	issue.Labels = i.Fields.Labels
+	if i.Fields.Status != nil {
+		issue.Status = i.Fields.Status.Name
+		issue.Done = i.Fields.Status.Name == "Done"
 	}
+
+	// Sites without the epic field link issues to their epic as the parent
+	switch {
+	case i.Fields.Type.Name == "Epic":
+		issue.Epic = i.Key
+	case i.Fields.Epic != nil:
+		issue.Epic = i.Fields.Epic.Key
+	case i.Fields.Parent != nil && !i.Fields.Type.Subtask:
+		issue.Epic = i.Fields.Parent.Key
+	}
+	return issue
+}
+
+// jiraWorklog is the body of a worklog request. The client's worklog record
+// drops the milliseconds jira requires from the started time.
+type jiraWorklog struct {
+	Started          string `json:"started"`
+	TimeSpentSeconds int    `json:"timeSpentSeconds"`
+	Comment          string `json:"comment"`
+}
+
+func newJiraWorklog(started time.Time, duration time.Duration, comment string) *jiraWorklog {
+	return &jiraWorklog{
+		Started:          started.Format(jiraWorklogTime),
+		TimeSpentSeconds: int(duration.Seconds()),
+		Comment:          comment,
+	}
+}
+
+func (t *jiraTracker) AddWorklog(key string, started time.Time, duration time.Duration, comment string) (string, error) {
+	if !jiraKeyPattern.MatchString(key) {
+		return "", errNotAnIssue
+	}
+
+	var record jira.WorklogRecord
+	if err := t.worklogRequest("POST", fmt.Sprintf("rest/api/2/issue/%s/worklog", key), newJiraWorklog(started, duration, comment), &record); err != nil {
+		return "", err
+	}
+	return record.ID, nil
+}
+
+func (t *jiraTracker) UpdateWorklog(key, id string, started time.Time, duration time.Duration, comment string) error {
+	return t.worklogRequest("PUT", fmt.Sprintf("rest/api/2/issue/%s/worklog/%s", key, id), newJiraWorklog(started, duration, comment), nil)
+}
+
+func (t *jiraTracker) DeleteWorklog(key, id string) error {
+	return t.worklogRequest("DELETE", fmt.Sprintf("rest/api/2/issue/%s/worklog/%s", key, id), nil, nil)
+}
+
+func (t *jiraTracker) worklogRequest(method, endpoint string, body, v interface{}) error {
+	req, err := t.client.NewRequest(method, endpoint, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := t.client.Do(req, v)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return errWorklogMissing
+	}
+	return err
 }
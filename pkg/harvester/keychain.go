@@ -0,0 +1,64 @@
+package harvester
+
+import (
+	"encoding/base64"
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// The secret key is stored in the keychain of the os through its command line
+// tool, security on macOS and secret-tool of the secret service on Linux.
+const (
+	keychainService = "harvester"
+	keychainAccount = "secret.key"
+)
+
+var errNoKeychain = errors.New("no keychain available on " + runtime.GOOS)
+
+// keychainGet returns the key stored in the keychain, nil when none is stored.
+func keychainGet() ([]byte, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keychainService, "-a", keychainAccount, "-w")
+	case "linux":
+		cmd = exec.Command("secret-tool", "lookup", "service", keychainService, "account", keychainAccount)
+	default:
+		return nil, errNoKeychain
+	}
+
+	out, err := cmd.Output()
+	if _, ok := err.(*exec.ExitError); ok {
+		// Both tools exit with an error when nothing is stored
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errNoKeychain
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+}
+
+// keychainSet stores the key in the keychain. An existing key is never
+// replaced on macOS, the one stored may still be needed when it could not be
+// read because the keychain is locked.
+func keychainSet(key []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(key)
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "add-generic-password", "-s", keychainService, "-a", keychainAccount, "-w", encoded)
+	case "linux":
+		cmd = exec.Command("secret-tool", "store", "--label=Harvester", "service", keychainService, "account", keychainAccount)
+		cmd.Stdin = strings.NewReader(encoded)
+	default:
+		return errNoKeychain
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.New(strings.TrimSpace(err.Error() + " " + string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package harvester
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/skratchdot/open-golang/open"
+	"golang.org/x/oauth2"
+)
+
+// oauthCallbackPath is where the browser is sent back to on the listener
+// after the user approves a login.
+const oauthCallbackPath = "/oauth/callback"
+
+var errNotLoggedIn = errors.New("not logged in, log in from the settings first")
+
+// oauthLogin is a login waiting for the user to approve it in the browser.
+// complete trades the query of the callback for a token and saves it, it runs
+// on the callback request and has to lock mu to change any state.
+type oauthLogin struct {
+	complete func(ctx context.Context, query url.Values) error
+}
+
+// startOAuth opens the authorization page of config in the browser. Once the
+// user approves, done is called with the token from the callback.
+func (h *harvester) startOAuth(config *oauth2.Config, done func(*oauth2.Token) error, opts ...oauth2.AuthCodeOption) error {
+	if err := h.checkOAuthRedirect(); err != nil {
+		return err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	state := base64.RawURLEncoding.EncodeToString(nonce)
+
+	config.RedirectURL = h.oauthRedirectURL()
+
+	h.addOAuthLogin(state, func(ctx context.Context, query url.Values) error {
+		if reason := query.Get("error"); reason != "" {
+			return fmt.Errorf("login was not approved: %s", reason)
+		}
+
+		token, err := config.Exchange(ctx, query.Get("code"))
+		if err != nil {
+			return err
+		}
+		return done(token)
+	})
+
+	return open.Run(config.AuthCodeURL(state, opts...))
+}
+
+// startOAuth1 gets a request token from config and opens its authorization
+// page in the browser. Once the user approves, done is called with the access
+// token.
+func (h *harvester) startOAuth1(config *oauth1Config, done func(*oauth1Token) error) error {
+	if err := h.checkOAuthRedirect(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	requestToken, err := config.requestToken(ctx, h.oauthRedirectURL())
+	if err != nil {
+		return err
+	}
+
+	// The request token comes back with the callback in place of a state
+	h.addOAuthLogin(requestToken.Token, func(ctx context.Context, query url.Values) error {
+		verifier := query.Get("oauth_verifier")
+		if verifier == "" || verifier == "denied" {
+			return errors.New("login was not approved")
+		}
+
+		token, err := config.accessToken(ctx, requestToken.Token, verifier)
+		if err != nil {
+			return err
+		}
+		return done(token)
+	})
+
+	return open.Run(config.authCodeURL(requestToken.Token))
+}
+
+// checkOAuthRedirect fails when the redirect url would not stay the same
+// across restarts, it has to match the one registered with the app.
+func (h *harvester) checkOAuthRedirect() error {
+	if h.listener == nil {
+		return errors.New("logging in needs the app to be running")
+	}
+	if !h.fixedPort {
+		return errors.New("logging in needs a fixed port, start harvester with -listen.addr 127.0.0.1:8765 and register http://127.0.0.1:8765" + oauthCallbackPath + " as the callback url")
+	}
+	return nil
+}
+
+func (h *harvester) addOAuthLogin(state string, complete func(context.Context, url.Values) error) {
+	h.oauthMu.Lock()
+	defer h.oauthMu.Unlock()
+
+	if h.oauthLogins == nil {
+		h.oauthLogins = make(map[string]*oauthLogin)
+	}
+	h.oauthLogins[state] = &oauthLogin{complete: complete}
+}
+
+// oauthRedirectURL is the callback url that has to be registered with the
+// app, the listener needs a fixed port for it to stay the same.
+func (h *harvester) oauthRedirectURL() string {
+	return "http://" + h.listener.Addr().String() + oauthCallbackPath
+}
+
+func (h *harvester) oauthCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// OAuth 2.0 sends back the state, OAuth 1.0a the request token
+	state := query.Get("state")
+	if state == "" {
+		state = query.Get("oauth_token")
+	}
+
+	h.oauthMu.Lock()
+	login := h.oauthLogins[state]
+	delete(h.oauthLogins, state)
+	h.oauthMu.Unlock()
+
+	if login == nil {
+		http.Error(w, "unknown or expired login, log in again from harvester", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := login.complete(ctx, query); err != nil {
+		h.mu.Lock()
+		h.sendError(err)
+		h.mu.Unlock()
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fmt.Fprintln(w, "Logged in, this window can be closed.")
+}
+
+// oauthClient is an http client authenticating with the saved token of
+// data, tokens are refreshed as needed and saved with the settings.
+func (h *harvester) oauthClient(config *oauth2.Config, data *SettingsData, transport http.RoundTripper) (*http.Client, error) {
+	token, err := data.oauthToken()
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, errNotLoggedIn
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: transport})
+	source := &savingTokenSource{
+		source: config.TokenSource(ctx, token),
+		last:   token.AccessToken,
+		save: func(token *oauth2.Token) error {
+			if err := data.setOAuthToken(token); err != nil {
+				return err
+			}
+			return h.Settings.Save(h.db, h.secrets)
+		},
+	}
+	return oauth2.NewClient(ctx, source), nil
+}
+
+// savingTokenSource saves every refreshed token so the refresh token is
+// still valid after a restart. Tokens are refreshed by the requests of the
+// client, which are only made holding mu, so saving needs no lock of its own.
+type savingTokenSource struct {
+	mu     sync.Mutex
+	source oauth2.TokenSource
+	last   string
+	save   func(*oauth2.Token) error
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token.AccessToken != s.last {
+		s.last = token.AccessToken
+		if err := s.save(token); err != nil {
+			log.Println("unable to save refreshed token", err)
+		}
+	}
+	return token, nil
+}
@@ -0,0 +1,257 @@
+package harvester
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Paths of the oauth 1.0a endpoints of jira server and data center
+const (
+	jiraOAuth1RequestPath   = "/plugins/servlet/oauth/request-token"
+	jiraOAuth1AuthorizePath = "/plugins/servlet/oauth/authorize"
+	jiraOAuth1AccessPath    = "/plugins/servlet/oauth/access-token"
+)
+
+// pemPattern finds a pem block even when its line breaks were lost pasting
+// it into a single line field.
+var pemPattern = regexp.MustCompile(`-----BEGIN ([A-Z ]+)-----([A-Za-z0-9+/=\s]+)-----END [A-Z ]+-----`)
+
+// oauth1Config is a consumer of an application link, signing requests with
+// its private key using RSA-SHA1.
+type oauth1Config struct {
+	consumerKey     string
+	privateKey      *rsa.PrivateKey
+	requestTokenURL string
+	authorizeURL    string
+	accessTokenURL  string
+}
+
+// oauth1Token is the access token of a user, saved as json in the settings.
+type oauth1Token struct {
+	Token  string `json:"token"`
+	Secret string `json:"secret"`
+}
+
+func jiraOAuth1Config(data *SettingsData) (*oauth1Config, error) {
+	if data.URL == "" || data.ClientID == "" || data.ClientSecret == "" {
+		return nil, &rpcError{Code: rpcErrBadRequest, Message: "url, consumer key and private key are required"}
+	}
+
+	key, err := parseRSAPrivateKey(data.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := strings.TrimSuffix(data.URL, "/")
+	return &oauth1Config{
+		consumerKey:     data.ClientID,
+		privateKey:      key,
+		requestTokenURL: baseURL + jiraOAuth1RequestPath,
+		authorizeURL:    baseURL + jiraOAuth1AuthorizePath,
+		accessTokenURL:  baseURL + jiraOAuth1AccessPath,
+	}, nil
+}
+
+// parseRSAPrivateKey reads a PKCS#1 or PKCS#8 pem encoded RSA key.
+func parseRSAPrivateKey(value string) (*rsa.PrivateKey, error) {
+	match := pemPattern.FindStringSubmatch(value)
+	if match == nil {
+		return nil, &rpcError{Code: rpcErrBadRequest, Message: "the private key is not pem encoded"}
+	}
+
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(match[2]), ""))
+	if err != nil {
+		return nil, &rpcError{Code: rpcErrBadRequest, Message: "invalid private key: " + err.Error()}
+	}
+
+	if match[1] == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(der)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, &rpcError{Code: rpcErrBadRequest, Message: "the private key is not an RSA key"}
+	}
+	return rsaKey, nil
+}
+
+// oauth1TokenOf returns the saved oauth 1.0a token, nil when there is none.
+func oauth1TokenOf(data *SettingsData) (*oauth1Token, error) {
+	if data.Token == "" {
+		return nil, nil
+	}
+
+	var token oauth1Token
+	if err := json.Unmarshal([]byte(data.Token), &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// requestToken gets a temporary token for the user to authorize, the user is
+// sent back to callback afterwards.
+func (c *oauth1Config) requestToken(ctx context.Context, callback string) (*oauth1Token, error) {
+	return c.tokenRequest(ctx, c.requestTokenURL, "", map[string]string{
+		"oauth_callback": callback,
+	})
+}
+
+// accessToken trades the authorized request token for an access token.
+func (c *oauth1Config) accessToken(ctx context.Context, requestToken, verifier string) (*oauth1Token, error) {
+	return c.tokenRequest(ctx, c.accessTokenURL, requestToken, map[string]string{
+		"oauth_verifier": verifier,
+	})
+}
+
+func (c *oauth1Config) authCodeURL(requestToken string) string {
+	return c.authorizeURL + "?oauth_token=" + url.QueryEscape(requestToken)
+}
+
+func (c *oauth1Config) tokenRequest(ctx context.Context, tokenURL, token string, params map[string]string) (*oauth1Token, error) {
+	req, err := http.NewRequest(http.MethodPost, tokenURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if err := c.sign(req, token, params); err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jira refused the oauth request: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	if values.Get("oauth_token") == "" {
+		return nil, errors.New("jira sent no oauth token")
+	}
+
+	return &oauth1Token{
+		Token:  values.Get("oauth_token"),
+		Secret: values.Get("oauth_token_secret"),
+	}, nil
+}
+
+// sign adds the authorization header of the consumer to req. The signature
+// covers the oauth parameters and the query, jira requests have no form
+// bodies.
+func (c *oauth1Config) sign(req *http.Request, token string, extra map[string]string) error {
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	params := map[string]string{
+		"oauth_consumer_key":     c.consumerKey,
+		"oauth_nonce":            base64.RawURLEncoding.EncodeToString(nonce),
+		"oauth_signature_method": "RSA-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
+		"oauth_version":          "1.0",
+	}
+	if token != "" {
+		params["oauth_token"] = token
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+
+	var pairs []string
+	for k, v := range params {
+		pairs = append(pairs, oauthEscape(k)+"="+oauthEscape(v))
+	}
+	for k, values := range req.URL.Query() {
+		for _, v := range values {
+			pairs = append(pairs, oauthEscape(k)+"="+oauthEscape(v))
+		}
+	}
+	sort.Strings(pairs)
+
+	endpoint := url.URL{
+		Scheme: strings.ToLower(req.URL.Scheme),
+		Host:   strings.ToLower(req.URL.Host),
+		Path:   req.URL.EscapedPath(),
+	}
+	base := req.Method + "&" + oauthEscape(endpoint.String()) + "&" + oauthEscape(strings.Join(pairs, "&"))
+
+	hash := sha1.Sum([]byte(base))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.privateKey, crypto.SHA1, hash[:])
+	if err != nil {
+		return err
+	}
+	params["oauth_signature"] = base64.StdEncoding.EncodeToString(signature)
+
+	var header []string
+	for k, v := range params {
+		header = append(header, fmt.Sprintf(`%s="%s"`, oauthEscape(k), oauthEscape(v)))
+	}
+	sort.Strings(header)
+	req.Header.Set("Authorization", "OAuth "+strings.Join(header, ", "))
+	return nil
+}
+
+// oauthEscape percent encodes everything but the unreserved characters of
+// RFC 3986 as oauth requires.
+func oauthEscape(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// oauth1Transport signs every request with the access token.
+type oauth1Transport struct {
+	config *oauth1Config
+	token  string
+	base   http.RoundTripper
+}
+
+func (t *oauth1Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Round trippers must not change the request they are given
+	signed := req.Clone(req.Context())
+	if err := t.config.sign(signed, t.token, nil); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(signed)
+}
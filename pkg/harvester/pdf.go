@@ -0,0 +1,256 @@
+package harvester
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Reports are laid out on US letter pages, sizes are in points.
+const (
+	pdfPageWidth  = 612.0
+	pdfPageHeight = 792.0
+	pdfMargin     = 54.0
+
+	// Columns of the report lines, hours are right aligned to the margin
+	pdfDescriptionX = pdfMargin + 130
+	pdfHoursWidth   = 60.0
+
+	pdfLineHeight = 14.0
+	pdfNotesSize  = 9.0
+	pdfTextSize   = 10.0
+)
+
+// The standard fonts every pdf reader has, nothing is embedded.
+const (
+	pdfFont     = "F1"
+	pdfFontBold = "F2"
+)
+
+// winAnsi maps the characters of the windows code page that differ from
+// latin-1, other characters outside latin-1 are printed as a question mark.
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92,
+	'“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+// pdfReport draws the report on pages from the top down, y is the baseline
+// of the current line.
+type pdfReport struct {
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	y     float64
+}
+
+// writeReportPDF writes the report as a pdf with the same content as the
+// html report.
+func writeReportPDF(w io.Writer, data reportData) error {
+	p := &pdfReport{}
+	p.newPage()
+
+	p.text(pdfFontBold, 20, pdfMargin, 0, data.Title)
+	p.y -= 20
+	p.text(pdfFont, 11, pdfMargin, 0.45, data.Period)
+	p.y -= 32
+
+	p.text(pdfFontBold, pdfTextSize, pdfMargin, 0, data.GroupBy)
+	p.text(pdfFontBold, pdfTextSize, pdfDescriptionX, 0, "Description")
+	p.textRight(pdfFontBold, pdfTextSize, 0, "Hours")
+	p.y -= 6
+	p.rule(1.5, 0)
+
+	descriptionWidth := pdfPageWidth - pdfMargin - pdfHoursWidth - pdfDescriptionX
+	for _, line := range data.Lines {
+		description := wrapPDFText(line.Description, pdfTextSize, descriptionWidth)
+		var notes []string
+		for _, n := range line.Notes {
+			notes = append(notes, wrapPDFText(n, pdfNotesSize, descriptionWidth)...)
+		}
+
+		p.fit(float64(len(description)+len(notes)+1) * pdfLineHeight)
+		p.y -= pdfLineHeight
+
+		p.text(pdfFont, pdfTextSize, pdfMargin, 0, truncatePDFText(line.Key, pdfTextSize, pdfDescriptionX-pdfMargin-8))
+		p.textRight(pdfFont, pdfTextSize, 0, fmt.Sprintf("%.2f", line.Hours))
+		for i, text := range description {
+			if i > 0 {
+				p.y -= pdfLineHeight
+			}
+			p.text(pdfFont, pdfTextSize, pdfDescriptionX, 0, text)
+		}
+		for _, text := range notes {
+			p.y -= pdfLineHeight - 2
+			p.text(pdfFont, pdfNotesSize, pdfDescriptionX, 0.45, text)
+		}
+
+		p.y -= 6
+		p.rule(0.5, 0.85)
+	}
+
+	p.fit(3 * pdfLineHeight)
+	p.y -= 2
+	p.rule(1.5, 0)
+	p.y -= pdfLineHeight
+	p.text(pdfFontBold, pdfTextSize, pdfMargin, 0, "Total")
+	p.textRight(pdfFontBold, pdfTextSize, 0, fmt.Sprintf("%.2f", data.Total))
+
+	p.y -= 2 * pdfLineHeight
+	p.text(pdfFont, 8, pdfMargin, 0.45, "Generated "+data.Generated.Format("Jan 2, 2006 15:04"))
+
+	return p.write(w)
+}
+
+func (p *pdfReport) newPage() {
+	p.page = &bytes.Buffer{}
+	p.pages = append(p.pages, p.page)
+	p.y = pdfPageHeight - pdfMargin
+}
+
+// fit starts a new page when height does not fit on the current one.
+func (p *pdfReport) fit(height float64) {
+	if p.y-height < pdfMargin {
+		p.newPage()
+	}
+}
+
+// text draws s from x on the current line, gray is 0 for black to 1 for white.
+func (p *pdfReport) text(font string, size, x, gray float64, s string) {
+	if s == "" {
+		return
+	}
+	fmt.Fprintf(p.page, "BT %.2f g /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", gray, font, size, x, p.y, pdfString(s))
+}
+
+// textRight draws s ending at the right margin.
+func (p *pdfReport) textRight(font string, size, gray float64, s string) {
+	p.text(font, size, pdfPageWidth-pdfMargin-pdfTextWidth(s, size), gray, s)
+}
+
+// rule draws a line across the page just below the current line.
+func (p *pdfReport) rule(width, gray float64) {
+	fmt.Fprintf(p.page, "%.2f G %.2f w %.2f %.2f m %.2f %.2f l S\n", gray, width, pdfMargin, p.y, pdfPageWidth-pdfMargin, p.y)
+	p.y -= 2
+}
+
+// write writes the pages as a pdf document with its cross reference table.
+func (p *pdfReport) write(w io.Writer) error {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// The catalog, page tree and fonts come first, then each page and its
+	// content
+	kids := make([]string, len(p.pages))
+	for i := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range p.pages {
+		object(fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /%s 3 0 R /%s 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, pdfFont, pdfFontBold, 6+2*i,
+		))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// pdfString encodes s as the body of a pdf string in the font encoding.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < ' ':
+			b.WriteByte(' ')
+		case r < 0x80:
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", r)
+		case winAnsi[r] != 0:
+			fmt.Fprintf(&b, "\\%03o", winAnsi[r])
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// pdfTextWidth estimates the width of s in Helvetica. Digits and the
+// separators of hours are exact so totals line up, other characters are
+// close enough to wrap lines.
+func pdfTextWidth(s string, size float64) float64 {
+	var units int
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			units += 556
+		case strings.ContainsRune(" .,:;!|'", r):
+			units += 278
+		case strings.ContainsRune("ijlft", r):
+			units += 250
+		case strings.ContainsRune("mwMW", r):
+			units += 900
+		case r >= 'A' && r <= 'Z':
+			units += 700
+		default:
+			units += 556
+		}
+	}
+	return float64(units) * size / 1000
+}
+
+// wrapPDFText breaks s into lines of at most width, words longer than a line
+// are truncated.
+func wrapPDFText(s string, size, width float64) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(s) {
+		switch {
+		case line == "":
+			line = truncatePDFText(word, size, width)
+		case pdfTextWidth(line+" "+word, size) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = truncatePDFText(word, size, width)
+		}
+	}
+	if line != "" || len(lines) == 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// truncatePDFText shortens s to fit in width, ending it with an ellipsis.
+func truncatePDFText(s string, size, width float64) string {
+	if pdfTextWidth(s, size) <= width {
+		return s
+	}
+
+	runes := []rune(s)
+	for len(runes) > 0 && pdfTextWidth(string(runes)+"…", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
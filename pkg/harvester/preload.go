@@ -0,0 +1,197 @@
+package harvester
+
+import (
+	"io/ioutil"
+	"path/filepath"
+)
+
+// preloadFile is written next to the icons and loaded into the window ahead
+// of the page.
+const preloadFile = "preload.js"
+
+// writePreload writes the preload script of the window, returning its path.
+// It is rewritten on every start so it matches the running version.
+func writePreload(harvesterDir string) (string, error) {
+	path := filepath.Join(harvesterDir, preloadFile)
+	return path, ioutil.WriteFile(path, []byte(preloadScript), 0600)
+}
+
+// preloadScript runs with node before the page, isolated from it. The page
+// has no node integration and only talks to the preload through messages, the
+// preload installs pageScript in the page to send them and answers with the
+// few electron apis harvester needs. Electron 4 has no contextBridge yet.
+const preloadScript = `const { ipcRenderer, remote, webFrame } = require('electron');
+const fs = require('fs');
+const path = require('path');
+
+const exportFormats = ['csv', 'json', 'html', 'pdf'];
+const subscribed = {};
+let watchingPower = false;
+
+function reply(message) {
+    window.postMessage(Object.assign({ harvester: 'reply' }, message), window.location.origin);
+}
+
+function callback(id) {
+    return function (err, value) {
+        reply({ kind: 'callback', id: id, error: err ? err.message : null, value: value });
+    };
+}
+
+window.addEventListener('message', function (event) {
+    const m = event.data;
+    if (event.source !== window || !m || m.harvester !== 'request') {
+        return;
+    }
+
+    switch (m.kind) {
+    case 'ipc.send':
+        ipcRenderer.send.apply(ipcRenderer, [m.channel].concat(m.args));
+        break;
+    case 'ipc.on':
+        if (!subscribed[m.channel]) {
+            subscribed[m.channel] = true;
+            ipcRenderer.on(m.channel, function (e, ...args) {
+                reply({ kind: 'ipc', channel: m.channel, args: args });
+            });
+        }
+        break;
+    case 'power.watch':
+        if (!watchingPower) {
+            watchingPower = true;
+            remote.powerMonitor.on('suspend', function () { reply({ kind: 'power', event: 'suspend' }); });
+            remote.powerMonitor.on('resume', function () { reply({ kind: 'power', event: 'resume' }); });
+        }
+        break;
+    case 'power.idle':
+        callback(m.id)(null, remote.powerMonitor.getSystemIdleTime());
+        break;
+    case 'saveFile':
+        saveFile(String(m.name), String(m.content), m.format, callback(m.id));
+        break;
+    }
+});
+
+// saveFile writes content to the file chosen in a save dialog, the page only
+// names the file. Pdfs are printed from the html content in a hidden window.
+function saveFile(name, content, format, callback) {
+    if (exportFormats.indexOf(format) < 0) {
+        callback(new Error('unknown export format ' + format));
+        return;
+    }
+
+    const options = {
+        defaultPath: path.basename(name),
+        filters: [{ name: format.toUpperCase(), extensions: [format] }],
+    };
+    remote.dialog.showSaveDialog(remote.getCurrentWindow(), options, function (file) {
+        if (!file) {
+            callback(null);
+            return;
+        }
+
+        if (format !== 'pdf') {
+            fs.writeFile(file, content, callback);
+            return;
+        }
+
+        const printer = new remote.BrowserWindow({
+            show: false,
+            webPreferences: { nodeIntegration: false, contextIsolation: true, javascript: false },
+        });
+        printer.webContents.on('did-finish-load', function () {
+            printer.webContents.printToPDF({ printBackground: true }, function (err, pdf) {
+                printer.close();
+                if (err) {
+                    callback(err);
+                    return;
+                }
+                fs.writeFile(file, pdf, callback);
+            });
+        });
+        printer.loadURL('data:text/html;charset=utf-8,' + encodeURIComponent(content));
+    });
+}
+
+webFrame.executeJavaScript(` + "`" + pageScript + "`" + `);
+`
+
+// pageScript runs in the page. It gives astilectron, which injects its bridge
+// with require('electron'), an ipcRenderer that goes through the preload, and
+// the page the harvester api.
+const pageScript = `(function () {
+    let nextId = 0;
+    const callbacks = {};
+    const listeners = {};
+    const power = { suspend: [], resume: [] };
+
+    window.addEventListener('message', function (event) {
+        const m = event.data;
+        if (event.source !== window || !m || m.harvester !== 'reply') {
+            return;
+        }
+
+        switch (m.kind) {
+        case 'callback': {
+            const callback = callbacks[m.id];
+            delete callbacks[m.id];
+            if (callback) {
+                callback(m.error ? new Error(m.error) : null, m.value);
+            }
+            break;
+        }
+        case 'ipc':
+            (listeners[m.channel] || []).forEach(function (listener) {
+                listener.apply(null, [{}].concat(m.args));
+            });
+            break;
+        case 'power':
+            power[m.event].forEach(function (listener) { listener(); });
+            break;
+        }
+    });
+
+    function request(kind, data, callback) {
+        const id = String(++nextId);
+        if (callback) {
+            callbacks[id] = callback;
+        }
+        window.postMessage(Object.assign({ harvester: 'request', kind: kind, id: id }, data), window.location.origin);
+    }
+
+    const ipcRenderer = {
+        send: function (channel, ...args) {
+            request('ipc.send', { channel: channel, args: args });
+        },
+        on: function (channel, listener) {
+            (listeners[channel] = listeners[channel] || []).push(listener);
+            request('ipc.on', { channel: channel });
+        },
+    };
+
+    window.require = function (name) {
+        if (name !== 'electron') {
+            throw new Error('cannot require ' + name);
+        }
+        return { ipcRenderer: ipcRenderer, remote: {} };
+    };
+
+    window.harvester = {
+        onSuspend: function (listener) {
+            power.suspend.push(listener);
+            request('power.watch', {});
+        },
+        onResume: function (listener) {
+            power.resume.push(listener);
+            request('power.watch', {});
+        },
+        systemIdleTime: function (callback) {
+            request('power.idle', {}, function (err, seconds) { callback(seconds); });
+        },
+        saveFile: function (name, content, format, callback) {
+            request('saveFile', { name: name, content: content, format: format }, callback);
+        },
+    };
+
+    window.dispatchEvent(new Event('harvester-ready'));
+})();`
@@ -0,0 +1,146 @@
+package harvester
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/asticode/go-astilectron"
+)
+
+const (
+	rpcErrUnknownCommand = "unknown_command"
+	rpcErrBadRequest     = "bad_request"
+	rpcErrInternal       = "internal"
+)
+
+// rpcRequest is the envelope sent from the front-end for every call.
+type rpcRequest struct {
+	ID      string          `json:"id"`
+	Command string          `json:"command"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// rpcResponse is returned to the front-end for every rpcRequest.
+type rpcResponse struct {
+	ID      string      `json:"id"`
+	Command string      `json:"command"`
+	Payload interface{} `json:"payload,omitempty"`
+	Error   *rpcError   `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// rpcEvent is pushed to the window outside of any request, like the errors
+// of the background refreshes and syncs.
+type rpcEvent struct {
+	Event string    `json:"event"`
+	Error *rpcError `json:"error,omitempty"`
+}
+
+const rpcEventError = "error"
+
+type rpcHandler func(payload json.RawMessage) (interface{}, error)
+
+// registerRPC adds a handler for the given command, replacing any existing one.
+func (h *harvester) registerRPC(command string, handler rpcHandler) {
+	if h.rpcHandlers == nil {
+		h.rpcHandlers = make(map[string]rpcHandler)
+	}
+	h.rpcHandlers[command] = handler
+}
+
+func (h *harvester) registerRPCHandlers(ready chan bool) {
+	h.registerWindowHandlers(ready)
+	h.registerTimerHandlers()
+	h.registerTimerEditHandlers()
+	h.registerTimesheetHandlers()
+	h.registerExportHandlers()
+	h.registerTimelineHandlers()
+	h.registerRecoveryHandlers()
+	h.registerIdleHandlers()
+	h.registerTaskMappingHandlers()
+	h.registerBackfillHandlers()
+	h.registerJiraHandlers()
+	h.registerHarvestHandlers()
+	h.registerCustomTaskHandlers()
+}
+
+func (h *harvester) mainListener(ready chan bool) {
+	h.registerRPCHandlers(ready)
+
+	h.mainWindow.OnMessage(func(m *astilectron.EventMessage) interface{} {
+		var req rpcRequest
+		if err := m.Unmarshal(&req); err != nil {
+			return rpcResponse{Error: &rpcError{Code: rpcErrBadRequest, Message: err.Error()}}
+		}
+
+		return h.handleRPC(req)
+	})
+}
+
+func (h *harvester) handleRPC(req rpcRequest) rpcResponse {
+	resp := rpcResponse{
+		ID:      req.ID,
+		Command: req.Command,
+	}
+
+	handler, ok := h.rpcHandlers[req.Command]
+	if !ok {
+		log.Println("unknown rpc handler " + req.Command)
+		resp.Error = &rpcError{Code: rpcErrUnknownCommand, Message: "unknown command " + req.Command}
+		return resp
+	}
+
+	h.mu.Lock()
+	payload, err := handler(req.Payload)
+	h.mu.Unlock()
+	if err != nil {
+		log.Printf("rpc %s: %s", req.Command, err)
+		resp.Error = toRPCError(err)
+		return resp
+	}
+
+	resp.Payload = payload
+	return resp
+}
+
+// decodePayload unmarshals the request payload into v, reporting failures as
+// a bad request to the caller.
+func decodePayload(payload json.RawMessage, v interface{}) error {
+	if len(payload) == 0 {
+		return &rpcError{Code: rpcErrBadRequest, Message: "missing payload"}
+	}
+
+	if err := json.Unmarshal(payload, v); err != nil {
+		return &rpcError{Code: rpcErrBadRequest, Message: fmt.Sprintf("invalid payload: %s", err)}
+	}
+	return nil
+}
+
+// toRPCError returns err as an rpc error, errors of handlers that are not are
+// reported as internal.
+func toRPCError(err error) *rpcError {
+	if rpcErr, ok := err.(*rpcError); ok {
+		return rpcErr
+	}
+	return &rpcError{Code: rpcErrInternal, Message: err.Error()}
+}
+
+// sendError shows an error that happened outside of any request in the
+// window, leaving the view as it is.
+func (h *harvester) sendError(err error) {
+	log.Println(err)
+	if h.mainWindow == nil {
+		return
+	}
+
+	h.mainWindow.SendMessage(rpcEvent{Event: rpcEventError, Error: toRPCError(err)})
+}
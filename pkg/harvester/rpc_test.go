@@ -0,0 +1,28 @@
+package harvester
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadyTwice(t *testing.T) {
+	h := &harvester{}
+	ready := make(chan bool, 1)
+	h.registerWindowHandlers(ready)
+
+	done := make(chan struct{})
+	go func() {
+		h.handleRPC(rpcRequest{Command: "ready"})
+		h.handleRPC(rpcRequest{Command: "ready"})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a second ready blocked")
+	}
+	if !<-ready {
+		t.Fatal("ready was not sent")
+	}
+}
@@ -0,0 +1,278 @@
+package harvester
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// passphraseEnv holds an optional master passphrase for stored secrets
+	passphraseEnv = "HARVESTER_PASSPHRASE"
+
+	secretPrefix  = "enc:v1:"
+	secretSaltKey = "secrets.salt"
+
+	// rewriteBackupFile holds the database values while it is rewritten
+	rewriteBackupFile = "db.rewrite"
+)
+
+var ErrSecretDecrypt = errors.New("unable to decrypt stored credentials, check " + passphraseEnv)
+
+// secretBox encrypts credentials before they are written to the database.
+// The key is derived from the master passphrase when one is set, otherwise a
+// random key is generated and kept in the keychain of the os, or in a file
+// outside of the harvester directory when there is none.
+type secretBox struct {
+	aead cipher.AEAD
+}
+
+func newSecretBox(db *badger.DB, passphrase, keyFile, legacyKeyFile string) (*secretBox, error) {
+	var key []byte
+	var err error
+	if passphrase != "" {
+		key, err = passphraseKey(db, passphrase)
+	} else {
+		key, err = secretKey(keyFile, legacyKeyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &secretBox{aead: aead}, nil
+}
+
+// passphraseKey derives the key from the passphrase using scrypt with a salt
+// stored next to the settings.
+func passphraseKey(db *badger.DB, passphrase string) ([]byte, error) {
+	var salt []byte
+	err := db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(secretSaltKey))
+		if err == nil {
+			salt, err = item.ValueCopy(nil)
+			return err
+		}
+		if err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		salt = make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return err
+		}
+		return txn.Set([]byte(secretSaltKey), salt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+// secretKey returns the random key, creating it if there is none yet. Keys
+// are moved to the keychain when it can be reached, from keyFile or from the
+// legacyFile next to the database older versions kept them in.
+func secretKey(keyFile, legacyFile string) ([]byte, error) {
+	key, err := keychainGet()
+	if err == nil && key != nil {
+		if len(key) != 32 {
+			return nil, errors.New("invalid key in the keychain")
+		}
+		return key, removeKeyFile(legacyFile, key)
+	}
+
+	key, err = readKeyFile(keyFile)
+	if err == nil && key == nil {
+		key, err = readKeyFile(legacyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		key = make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := keychainSet(key); err != nil {
+		log.Printf("keeping the secret key in %s, the keychain is not available: %s", keyFile, err)
+	} else {
+		if err := removeKeyFile(keyFile, key); err != nil {
+			return nil, err
+		}
+		return key, removeKeyFile(legacyFile, key)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(keyFile, key, 0600); err != nil {
+		return nil, err
+	}
+
+	return key, removeKeyFile(legacyFile, key)
+}
+
+// readKeyFile returns the key in keyFile, nil when the file does not exist.
+func readKeyFile(keyFile string) ([]byte, error) {
+	key, err := ioutil.ReadFile(keyFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) != 32 {
+		return nil, errors.New("invalid key in " + keyFile)
+	}
+	return key, nil
+}
+
+// removeKeyFile removes keyFile once the key it holds is stored elsewhere.
+// Files with another key are kept, they may still be needed to decrypt.
+func removeKeyFile(keyFile string, key []byte) error {
+	stored, err := readKeyFile(keyFile)
+	if err != nil || stored == nil || !bytes.Equal(stored, key) {
+		return nil
+	}
+	return os.Remove(keyFile)
+}
+
+func (b *secretBox) encrypt(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := b.aead.Seal(nonce, nonce, []byte(value), nil)
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt returns the plain value of an encrypted secret. Values stored
+// before encryption was added are returned as is with plaintext set.
+func (b *secretBox) decrypt(value string) (plain string, plaintext bool, err error) {
+	if !strings.HasPrefix(value, secretPrefix) {
+		return value, value != "", nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, secretPrefix))
+	if err != nil {
+		return "", false, err
+	}
+
+	nonceSize := b.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", false, ErrSecretDecrypt
+	}
+
+	opened, err := b.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", false, ErrSecretDecrypt
+	}
+
+	return string(opened), false, nil
+}
+
+// rewriteDatabase drops the earlier versions of every value, like the
+// credentials saved before they were encrypted. Badger keeps them in its value
+// log and tables until they are compacted away, so the latest values are
+// copied out, everything is dropped and the values are written back. The copy
+// is kept in backupFile until then, restoreDatabase writes it back when
+// harvester stopped half way.
+func rewriteDatabase(db *badger.DB, backupFile string) error {
+	var values []storedValue
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			values = append(values, storedValue{Key: it.Item().KeyCopy(nil), Value: value})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	backup, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(backupFile, backup, 0600); err != nil {
+		return err
+	}
+
+	if err := db.DropAll(); err != nil {
+		return err
+	}
+	return restoreDatabase(db, backupFile)
+}
+
+// storedValue is a database value in the backup of rewriteDatabase.
+type storedValue struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// restoreDatabase writes back the values of an unfinished rewrite, if any.
+func restoreDatabase(db *badger.DB, backupFile string) error {
+	backup, err := ioutil.ReadFile(backupFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var values []storedValue
+	if err := json.Unmarshal(backup, &values); err != nil {
+		return err
+	}
+
+	batch := db.NewWriteBatch()
+	defer batch.Cancel()
+	for _, v := range values {
+		if err := batch.Set(v.Key, v.Value); err != nil {
+			return err
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		return err
+	}
+
+	return os.Remove(backupFile)
+}
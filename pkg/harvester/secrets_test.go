@@ -0,0 +1,74 @@
+package harvester
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestRewriteDatabase(t *testing.T) {
+	dir := t.TempDir()
+	plaintext := []byte(`{"jira":{"pass":"plaintext password saved before encryption"}}`)
+
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := func(key, value []byte) {
+		err := db.Update(func(txn *badger.Txn) error {
+			return txn.Set(key, value)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	set([]byte("settings"), plaintext)
+	set([]byte("timer"), []byte("kept"))
+	set([]byte("settings"), []byte(`{"jira":{"pass":"enc:v1:sealed"}}`))
+
+	backupFile := filepath.Join(t.TempDir(), rewriteBackupFile)
+	if err := rewriteDatabase(db, backupFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(backupFile); !os.IsNotExist(err) {
+		t.Errorf("backup %s was not removed", backupFile)
+	}
+
+	err = db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("timer"))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			if string(val) != "kept" {
+				t.Errorf("timer is %q after the rewrite", val)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, plaintext) {
+			t.Errorf("%s still holds the plaintext settings", f.Name())
+		}
+	}
+}
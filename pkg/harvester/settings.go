@@ -5,35 +5,205 @@ import (
 	"time"
 
 	"github.com/dgraph-io/badger"
+	"github.com/jinzhu/now"
+	"golang.org/x/oauth2"
 )
 
 const (
 	defaultRefreshInterval = 5 * time.Minute
+
+	// defaultSyncWeeks is how far back harvest is synced when not configured
+	defaultSyncWeeks = 5
+
+	// maxSyncWeeks keeps the synced days within the days stored timers are
+	// kept, older days would be imported and purged again on every sync
+	maxSyncWeeks = storedTimerDays / 7
+
+	// Timer modes, single stops the running timer when another is started
+	timerModeSingle   = "single"
+	timerModeParallel = "parallel"
 )
 
 type Settings struct {
 	Jira    SettingsData `json:"jira"`
 	Harvest SettingsData `json:"harvest"`
+
+	// JiraQueries are the searches used to find issues, the default active
+	// issue query is used when none are set
+	JiraQueries []JiraQuery `json:"jiraQueries"`
+
+	// ExcludedProjects are harvest project ids hidden from the timers
+	ExcludedProjects []int64 `json:"excludedProjects"`
+
+	// IdleMinutes is how long the system can be idle before asking what to
+	// do with the time, zero disables idle detection
+	IdleMinutes int `json:"idleMinutes"`
+
+	// TimerMode is whether timers run one at a time or in parallel, see the
+	// timer mode constants
+	TimerMode string `json:"timerMode"`
+
+	// SyncWeeks is how many weeks of time are synced with harvest
+	SyncWeeks int `json:"syncWeeks"`
+
+	// JiraWorklogs logs the tracked time of issues as jira worklogs
+	JiraWorklogs bool `json:"jiraWorklogs"`
+
+	// JiraWorklogsSince is the day worklogs were turned on, time tracked
+	// before may have been logged by hand so it is left alone
+	JiraWorklogsSince *time.Time `json:"jiraWorklogsSince,omitempty"`
+
+	// SyncStrategy decides which side wins when the local and harvest hours
+	// of a day have both changed, see the syncStrategy constants
+	SyncStrategy string `json:"syncStrategy"`
+
+	// plaintext is set when secrets were loaded unencrypted and need saving
+	plaintext bool
 }
 
 type SettingsData struct {
 	URL  string `json:"url"`
 	User string `json:"user"`
 	Pass string `json:"pass"`
+
+	// Auth is how to authenticate, see the auth constants of the service
+	Auth string `json:"auth"`
+
+	// ClientID and ClientSecret identify the app registered for oauth
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+
+	// Token is the oauth token encoded as json
+	Token string `json:"token"`
+
+	// APIURL is where requests are sent when it differs from URL
+	APIURL string `json:"apiUrl"`
 }
 
-func (s *Settings) Save(db *badger.DB) error {
-	settings, err := json.Marshal(s)
+// secrets are the fields encrypted before saving.
+func (d *SettingsData) secrets() []*string {
+	return []*string{&d.Pass, &d.ClientSecret, &d.Token}
+}
+
+// oauthToken returns the saved oauth token, nil when there is none.
+func (d *SettingsData) oauthToken() (*oauth2.Token, error) {
+	if d.Token == "" {
+		return nil, nil
+	}
+
+	var token oauth2.Token
+	if err := json.Unmarshal([]byte(d.Token), &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (d *SettingsData) setOAuthToken(token *oauth2.Token) error {
+	data, err := json.Marshal(token)
 	if err != nil {
 		return err
 	}
 
-	return db.Update(func(txn *badger.Txn) error {
+	d.Token = string(data)
+	return nil
+}
+
+func (s *Settings) jiraQueries() []JiraQuery {
+	if len(s.JiraQueries) == 0 {
+		return []JiraQuery{{Name: defaultQueryName, JQL: defaultIssueQuery}}
+	}
+	return s.JiraQueries
+}
+
+func (s *Settings) syncWeeks() int {
+	switch {
+	case s.SyncWeeks <= 0:
+		return defaultSyncWeeks
+	case s.SyncWeeks > maxSyncWeeks:
+		return maxSyncWeeks
+	}
+	return s.SyncWeeks
+}
+
+// trackWorklogsSince sets JiraWorklogsSince to today when worklogs are
+// turned on and clears it when they are turned off, reporting if it changed.
+func (s *Settings) trackWorklogsSince() bool {
+	switch {
+	case s.JiraWorklogs && s.JiraWorklogsSince == nil:
+		today := now.BeginningOfDay()
+		s.JiraWorklogsSince = &today
+		return true
+	case !s.JiraWorklogs && s.JiraWorklogsSince != nil:
+		s.JiraWorklogsSince = nil
+		return true
+	}
+	return false
+}
+
+func (s *Settings) timerMode() string {
+	if s.TimerMode == timerModeParallel {
+		return timerModeParallel
+	}
+	return timerModeSingle
+}
+
+func (s *Settings) syncStrategy() string {
+	switch s.SyncStrategy {
+	case syncLocal, syncRemote, syncMax, syncManual:
+		return s.SyncStrategy
+	}
+	return syncLocal
+}
+
+func (s *Settings) projectExcluded(id int64) bool {
+	for _, excluded := range s.ExcludedProjects {
+		if excluded == id {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutSecrets returns a copy of the settings safe to hand out over the api.
+func (s *Settings) withoutSecrets() Settings {
+	settings := *s
+	for _, data := range []*SettingsData{&settings.Jira, &settings.Harvest} {
+		for _, secret := range data.secrets() {
+			*secret = ""
+		}
+	}
+	return settings
+}
+
+func (s *Settings) Save(db *badger.DB, secrets *secretBox) error {
+	encrypted := *s
+
+	for _, data := range []*SettingsData{&encrypted.Jira, &encrypted.Harvest} {
+		for _, secret := range data.secrets() {
+			var err error
+			if *secret, err = secrets.encrypt(*secret); err != nil {
+				return err
+			}
+		}
+	}
+
+	settings, err := json.Marshal(encrypted)
+	if err != nil {
+		return err
+	}
+
+	err = db.Update(func(txn *badger.Txn) error {
 		return txn.Set([]byte("settings"), settings)
 	})
+	if err != nil {
+		return err
+	}
+
+	s.plaintext = false
+	return nil
 }
 
-func GetSettings(db *badger.DB) (*Settings, error) {
+func GetSettings(db *badger.DB, secrets *secretBox) (*Settings, error) {
 	var settingsValue []byte
 	err := db.View(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte("settings"))
@@ -53,5 +223,17 @@ func GetSettings(db *badger.DB) (*Settings, error) {
 		return nil, err
 	}
 
+	for _, data := range []*SettingsData{&settings.Jira, &settings.Harvest} {
+		for _, secret := range data.secrets() {
+			plain, plaintext, err := secrets.decrypt(*secret)
+			if err != nil {
+				return nil, err
+			}
+
+			*secret = plain
+			settings.plaintext = settings.plaintext || plaintext
+		}
+	}
+
 	return &settings, nil
 }
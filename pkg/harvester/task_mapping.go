@@ -0,0 +1,195 @@
+package harvester
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+)
+
+const (
+	taskMappingKey = "taskmap"
+
+	// fallbackTaskName is used for projects without a configured task
+	fallbackTaskName = "Coding"
+)
+
+// TaskMapping chooses the backend task time is logged to for each project.
+// Rules are checked in order before falling back to the project default.
+type TaskMapping struct {
+	Projects map[int64]int64 `json:"projects"`
+	Rules    []TaskRule      `json:"rules"`
+}
+
+// TaskRule logs time for issues of a type or with a label to a task.
+type TaskRule struct {
+	ProjectID int64  `json:"projectId"`
+	IssueType string `json:"issueType"`
+	Label     string `json:"label"`
+	TaskID    int64  `json:"taskId"`
+}
+
+func (r TaskRule) matches(issue *Issue) bool {
+	if issue == nil {
+		return false
+	}
+
+	if r.IssueType != "" && !strings.EqualFold(r.IssueType, issue.Type) {
+		return false
+	}
+
+	if r.Label != "" {
+		found := false
+		for _, label := range issue.Labels {
+			if strings.EqualFold(r.Label, label) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return r.IssueType != "" || r.Label != ""
+}
+
+// taskID returns the id of the backend task to log time for the timer to.
+func (m *TaskMapping) taskID(t *TaskTimer) (int64, error) {
+	if t.Custom != nil && t.Custom.TaskID != 0 {
+		return t.Custom.TaskID, nil
+	}
+
+	projectID := t.Project.ID
+
+	for _, rule := range m.Rules {
+		if rule.ProjectID == projectID && rule.matches(t.Issue) {
+			return rule.TaskID, nil
+		}
+	}
+
+	if taskID, ok := m.Projects[projectID]; ok {
+		return taskID, nil
+	}
+
+	for _, task := range t.Project.Tasks {
+		if task.Name == fallbackTaskName {
+			return task.ID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no task chosen for project %s", t.Project.Name)
+}
+
+func getTaskMapping(db *badger.DB) (*TaskMapping, error) {
+	mapping := &TaskMapping{
+		Projects: make(map[int64]int64),
+	}
+
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(taskMappingKey))
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return nil
+			}
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, mapping)
+		})
+	})
+
+	return mapping, err
+}
+
+func (m *TaskMapping) Save(db *badger.DB) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(taskMappingKey), data)
+	})
+}
+
+// harvestProjectOption is a project that can be included in or excluded from
+// the timers in the settings.
+type harvestProjectOption struct {
+	ID       int64  `json:"id"`
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	Client   string `json:"client"`
+	Excluded bool   `json:"excluded"`
+}
+
+type taskMappingData struct {
+	Mapping  *TaskMapping `json:"mapping"`
+	Projects Projects     `json:"projects"`
+}
+
+func (h *harvester) registerTaskMappingHandlers() {
+	h.registerRPC("taskmap.get", func(json.RawMessage) (interface{}, error) {
+		mapping, err := getTaskMapping(h.db)
+		if err != nil {
+			return nil, err
+		}
+
+		return taskMappingData{
+			Mapping:  mapping,
+			Projects: h.timerProjects(),
+		}, nil
+	})
+
+	h.registerRPC("harvest.projects", func(json.RawMessage) (interface{}, error) {
+		if h.backend == nil {
+			return []harvestProjectOption{}, nil
+		}
+
+		projects, err := h.backend.Projects()
+		if err != nil {
+			return nil, err
+		}
+
+		options := make([]harvestProjectOption, 0, len(projects))
+		for _, project := range projects {
+			options = append(options, harvestProjectOption{
+				ID:       project.ID,
+				Code:     project.Code,
+				Name:     project.Name,
+				Client:   project.Client,
+				Excluded: h.Settings.projectExcluded(project.ID),
+			})
+		}
+		return options, nil
+	})
+
+	h.registerRPC("taskmap.save", func(payload json.RawMessage) (interface{}, error) {
+		var mapping TaskMapping
+		if err := decodePayload(payload, &mapping); err != nil {
+			return nil, err
+		}
+
+		for _, rule := range mapping.Rules {
+			if rule.IssueType == "" && rule.Label == "" {
+				return nil, &rpcError{Code: rpcErrBadRequest, Message: "rules need an issue type or label"}
+			}
+		}
+
+		return nil, mapping.Save(h.db)
+	})
+}
+
+// timerProjects lists the projects of all timers with a backend project,
+// custom tasks choose their own task so their projects are left out.
+func (h *harvester) timerProjects() Projects {
+	projects := make(Projects, 0)
+	for _, timer := range h.Timers {
+		if timer.Project != nil && timer.Custom == nil {
+			projects = append(projects, timer.Project)
+		}
+	}
+	return projects
+}
@@ -7,9 +7,9 @@ import (
 	"log"
 	"time"
 
-	jira "github.com/andygrunwald/go-jira"
 	"github.com/dgraph-io/badger"
 	"github.com/jinzhu/now"
+	"github.com/skratchdot/open-golang/open"
 )
 
 var (
@@ -17,43 +17,150 @@ var (
 )
 
 type TaskTimer struct {
-	Key       string       `json:"key"`
-	StartedAt *time.Time   `json:"startedAt"`
-	Running   bool         `json:"running"`
-	Runtime   string       `json:"runtime"`
-	Jira      *jira.Issue  `json:"jira"`
-	Harvest   *harvestTask `json:"harvest"`
+	Key       string     `json:"key"`
+	StartedAt *time.Time `json:"startedAt"`
+	Running   bool       `json:"running"`
+	Runtime   string     `json:"runtime"`
+	Issue     *Issue     `json:"issue"`
+	Project   *Project   `json:"project"`
+
+	// Entry is the running timer of the backend
+	Entry *Entry `json:"entry"`
+
+	// Custom is set for timers of custom tasks
+	Custom *CustomTask `json:"custom"`
+
+	// Notes describe the work, they are stored with the time when stopped
+	Notes string `json:"notes"`
+
+	// Paused timers keep the time worked before pausing in Pending, it is
+	// stored once the timer is stopped or the day is over
+	Paused  bool           `json:"paused"`
+	Pending []TimeInterval `json:"pending"`
 }
 type TaskTimers []*TaskTimer
 
+// StoredTimer holds the intervals tracked for a key on a single day. Duration
+// is the total of the intervals.
 type StoredTimer struct {
-	dbKey    []byte
-	Key      string        `json:"key"`
-	Day      time.Time     `json:"day"`
-	Duration time.Duration `json:"duration"`
+	dbKey     []byte
+	Key       string         `json:"key"`
+	Day       time.Time      `json:"day"`
+	Duration  time.Duration  `json:"duration"`
+	Intervals []TimeInterval `json:"intervals"`
+
+	// SyncedHours are the hours harvest had when the day was last synced
+	SyncedHours *float64 `json:"syncedHours,omitempty"`
+
+	// Notes describe the work of the day and are synced with the time
+	Notes string `json:"notes,omitempty"`
+
+	// SyncedNotes are the notes the day had when it was last synced, notes
+	// are only pushed to harvest once they are edited locally
+	SyncedNotes string `json:"syncedNotes,omitempty"`
 }
 type StoredTimers []StoredTimer
 
+type timerRequest struct {
+	Key string `json:"key"`
+}
+
+func (h *harvester) registerTimerHandlers() {
+	timerAction := func(action func(*TaskTimer) error) rpcHandler {
+		return func(payload json.RawMessage) (interface{}, error) {
+			var req timerRequest
+			if err := decodePayload(payload, &req); err != nil {
+				return nil, err
+			}
+
+			task, err := h.Timers.GetByKey(req.Key)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := action(task); err != nil {
+				return nil, err
+			}
+
+			h.sendTimers(false, false)
+			return nil, nil
+		}
+	}
+
+	h.registerRPC("timer.start", timerAction(h.StartTimer))
+	h.registerRPC("timer.stop", timerAction(h.StopTimer))
+	h.registerRPC("timer.pause", timerAction(h.PauseTimer))
+	h.registerRPC("timer.open", timerAction(func(t *TaskTimer) error {
+		if t.Issue != nil {
+			return open.Run(t.Issue.URL)
+		}
+
+		u, err := h.issueURL(t.Key)
+		if err != nil {
+			return err
+		}
+		return open.Run(u)
+	}))
+}
+
+// StartTimer starts the timer, or resumes it when paused. Unless timers run in
+// parallel any running timer is stopped first.
 func (h *harvester) StartTimer(t *TaskTimer) error {
-	if err := h.stopAllTimers(); err != nil {
-		return err
+	// Restarting a running timer stores its pending time when it is stopped
+	pending := t.Pending
+	if t.Running {
+		pending = nil
+	}
+
+	entry := t.Entry
+	if h.Settings.timerMode() == timerModeParallel {
+		if t.Running {
+			return nil
+		}
+	} else {
+		if err := h.stopAllTimers(); err != nil {
+			return err
+		}
+
+		// The backend entry of the timer was stopped with it
+		entry = nil
 	}
 
 	startedAt := time.Now().UTC()
 	newTimer := &TaskTimer{
 		Key:       t.Key,
-		Jira:      t.Jira,
-		Harvest:   t.Harvest,
+		Issue:     t.Issue,
+		Project:   t.Project,
+		Entry:     entry,
+		Custom:    t.Custom,
+		Notes:     t.Notes,
 		StartedAt: &startedAt,
 		Running:   true,
+		Pending:   pending,
 	}
 	newTimer.Runtime = newTimer.CurrentRuntime()
 
-	// If a harvest task exists start the timer for it
-	if newTimer.Harvest != nil {
-		if err := newTimer.Harvest.startTimer(h.harvestClient); err != nil {
+	// If a backend project exists start the timer for it
+	if newTimer.Project != nil && newTimer.Entry == nil {
+		mapping, err := getTaskMapping(h.db)
+		if err != nil {
+			return err
+		}
+
+		taskID, err := mapping.taskID(newTimer)
+		if err != nil {
 			return err
 		}
+
+		entry, err := h.backend.StartTimer(newTimer.Project.ID, taskID, newTimer.notes())
+		if err != nil {
+			return err
+		}
+		newTimer.Entry = entry
+	}
+
+	if err := h.saveActiveTimer(newTimer); err != nil {
+		return err
 	}
 
 	h.replaceTask(newTimer)
@@ -61,73 +168,167 @@ func (h *harvester) StartTimer(t *TaskTimer) error {
 }
 
 func (h *harvester) StopTimer(t *TaskTimer) error {
+	return h.stopTimerAt(t, time.Now().UTC(), true)
+}
+
+// PauseTimer stops the clock of a running timer without storing the time
+// worked so far, starting the timer again resumes it.
+func (h *harvester) PauseTimer(t *TaskTimer) error {
 	if !t.Running {
 		return nil
 	}
 
-	// Get any existing times in the database
-	var timer *StoredTimer
-	err := h.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(t.getDBKey())
-		if err != nil {
-			if err == badger.ErrKeyNotFound {
-				return nil
-			}
+	pausedAt := time.Now().UTC()
+	if pausedAt.After(*t.StartedAt) {
+		t.Pending = append(t.Pending, TimeInterval{
+			Start:  *t.StartedAt,
+			End:    pausedAt,
+			Source: intervalSourceTimer,
+		})
+	}
+
+	// The backend timer is started again on resume
+	if t.Entry != nil && h.backend != nil {
+		if _, err := h.backend.StopTimer(t.Entry.ID); err != nil {
 			return err
 		}
+	}
 
-		return item.Value(func(val []byte) error {
-			return json.Unmarshal(val, &timer)
-		})
-	})
-	if err != nil {
-		return err
+	t.Entry = nil
+	t.StartedAt = nil
+	t.Running = false
+	t.Paused = true
+	return h.saveActiveTimer(t)
+}
+
+// stopTimerAt stops a running or paused timer storing the time worked up
+// until end. When store is false the time since the timer was last started is
+// discarded, time worked before a pause is always kept.
+func (h *harvester) stopTimerAt(t *TaskTimer, end time.Time, store bool) error {
+	if !t.Running && !t.Paused {
+		return nil
 	}
 
-	if timer == nil {
-		timer = &StoredTimer{
-			Key:      t.Key,
-			Day:      now.BeginningOfDay(),
-			Duration: time.Since(*t.StartedAt),
-		}
-	} else {
-		timer.Duration = timer.Duration + time.Since(*t.StartedAt)
+	intervals := t.Pending
+	if store && t.Running && end.After(*t.StartedAt) {
+		intervals = append(intervals, TimeInterval{
+			Start:  *t.StartedAt,
+			End:    end,
+			Source: intervalSourceTimer,
+		})
 	}
 
-	err = h.db.Update(func(txn *badger.Txn) error {
-		data, err := json.Marshal(timer)
-		if err != nil {
+	// Credit the time to each day the timer was running on
+	for _, interval := range intervals {
+		if err := h.storeInterval(t.Key, interval, t.notes()); err != nil {
 			return err
 		}
+	}
 
-		return txn.Set(t.getDBKey(), data)
-	})
-	if err != nil {
+	if err := h.deleteActiveTimer(t.Key); err != nil {
 		return err
 	}
 
-	if t.Harvest != nil {
-		return t.Harvest.stopTimer(h.harvestClient)
+	if t.Entry != nil && h.backend != nil {
+		if _, err := h.backend.StopTimer(t.Entry.ID); err != nil {
+			return err
+		}
 	}
 
 	// Rest the in memory task
 	newTimer := &TaskTimer{
 		Key:     t.Key,
-		Jira:    t.Jira,
-		Harvest: t.Harvest,
+		Issue:   t.Issue,
+		Project: t.Project,
+		Custom:  t.Custom,
 	}
 	h.replaceTask(newTimer)
 	return nil
 }
 
+// notes returns the notes of the timer, the default notes when none are set.
+func (t *TaskTimer) notes() string {
+	if t.Notes != "" {
+		return t.Notes
+	}
+	return t.defaultNotes()
+}
+
+// defaultNotes are the issue key and summary or the custom task title.
+func (t *TaskTimer) defaultNotes() string {
+	switch {
+	case t.Issue != nil:
+		return t.Key + ": " + t.Issue.Summary
+	case t.Custom != nil:
+		return t.Custom.Title
+	}
+	return t.Key
+}
+
+// setTimerNotes changes the notes of the timer, updating the backend timer
+// when it is running.
+func (h *harvester) setTimerNotes(t *TaskTimer, notes string) error {
+	t.Notes = notes
+	if t.Entry != nil && h.backend != nil {
+		if _, err := h.backend.UpdateNotes(t.Entry.ID, t.notes()); err != nil {
+			return err
+		}
+	}
+
+	if t.Running || t.Paused {
+		return h.saveActiveTimer(t)
+	}
+	return nil
+}
+
+// commitPastDays stores the pending time of days before today, the rest is
+// kept until the timer is stopped.
+func (h *harvester) commitPastDays(t *TaskTimer) error {
+	today := now.BeginningOfDay()
+
+	var pending []TimeInterval
+	for _, interval := range t.Pending {
+		if !interval.Start.Before(today) {
+			pending = append(pending, interval)
+			continue
+		}
+		if err := h.storeInterval(t.Key, interval, t.notes()); err != nil {
+			return err
+		}
+	}
+
+	if len(pending) == len(t.Pending) {
+		return nil
+	}
+
+	t.Pending = pending
+	return h.saveActiveTimer(t)
+}
+
+// uncommitted returns the time of the timer not stored yet, the pending time
+// and the time since the timer was started up until end.
+func (t *TaskTimer) uncommitted(end time.Time) []TimeInterval {
+	intervals := append([]TimeInterval{}, t.Pending...)
+	if t.StartedAt != nil && end.After(*t.StartedAt) {
+		intervals = append(intervals, TimeInterval{
+			Start:  *t.StartedAt,
+			End:    end,
+			Source: intervalSourceRunning,
+		})
+	}
+	return intervals
+}
+
 func (t *TaskTimer) getDBKey() []byte {
-	return []byte(fmt.Sprintf("timer.%s.%s", t.Key, t.StartedAt.Format("20060102")))
+	return storedTimerKey(t.Key, t.StartedAt.Local())
 }
 
 func (h *harvester) saveTimer(t *TaskTimer) error {
 	taskCopy := *t
-	taskCopy.Jira = nil
-	taskCopy.Harvest = nil
+	taskCopy.Issue = nil
+	taskCopy.Project = nil
+	taskCopy.Entry = nil
+	taskCopy.Custom = nil
 	taskData, err := json.Marshal(taskCopy)
 	if err != nil {
 		return err
@@ -149,15 +350,33 @@ func (h *harvester) replaceTask(t *TaskTimer) {
 	h.Timers = append(h.Timers, t)
 }
 
+func (h *harvester) removeTask(key string) {
+	for i, task := range h.Timers {
+		if task.Key == key {
+			h.Timers = append(h.Timers[:i], h.Timers[i+1:]...)
+			return
+		}
+	}
+}
+
+// CurrentRuntime is the time worked since the timer was started, including
+// the time before any pauses.
 func (t *TaskTimer) CurrentRuntime() string {
-	if t.StartedAt == nil {
+	if t.StartedAt == nil && len(t.Pending) == 0 {
 		return ""
 	}
 
-	runTime := time.Since(*t.StartedAt)
+	var runTime time.Duration
+	for _, interval := range t.uncommitted(time.Now().UTC()) {
+		runTime += interval.Duration()
+	}
 	return fmt.Sprintf("%02d:%02.0f", int(runTime.Hours()), runTime.Minutes()-float64(int(runTime.Hours())*60))
 }
 
+// storedTimerDays is how long the time of a day and the applied backfill
+// changes are kept.
+const storedTimerDays = 90
+
 // StartJiraPurger will check for old jiras every few hours and purge any that are more than 90 days old
 func StartJiraPurger(db *badger.DB) {
 	purge := func() error {
@@ -166,10 +385,15 @@ func StartJiraPurger(db *badger.DB) {
 			return err
 		}
 
+		if err := purgeBackfillLog(db, storedTimerDays); err != nil {
+			return err
+		}
+
 		return db.Update(func(txn *badger.Txn) error {
+			// Timers are sorted by key before the day so check all of them
 			for _, timer := range timers {
-				if time.Since(timer.Day).Hours() < (90 * 24) {
-					return nil
+				if time.Since(timer.Day).Hours() < (storedTimerDays * 24) {
+					continue
 				}
 
 				if err := txn.Delete(timer.dbKey); err != nil {
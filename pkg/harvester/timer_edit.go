@@ -0,0 +1,219 @@
+package harvester
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/jinzhu/now"
+)
+
+const maxDayDuration = 24 * time.Hour
+
+// timerEditRequest adds, sets or deletes the tracked time of a key on a day.
+// Day is a local date formatted as 2006-01-02.
+type timerEditRequest struct {
+	Key   string  `json:"key"`
+	Day   string  `json:"day"`
+	Hours float64 `json:"hours"`
+	Notes string  `json:"notes"`
+}
+
+func (r timerEditRequest) parse() (time.Time, time.Duration, error) {
+	if r.Key == "" {
+		return time.Time{}, 0, &rpcError{Code: rpcErrBadRequest, Message: "key is required"}
+	}
+
+	day, err := time.ParseInLocation("2006-01-02", r.Day, time.Local)
+	if err != nil {
+		return time.Time{}, 0, &rpcError{Code: rpcErrBadRequest, Message: "invalid day " + r.Day}
+	}
+
+	return day, time.Duration(r.Hours * float64(time.Hour)), nil
+}
+
+func (h *harvester) registerTimerEditHandlers() {
+	h.registerRPC("timer.add", func(payload json.RawMessage) (interface{}, error) {
+		var req timerEditRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return nil, err
+		}
+
+		day, duration, err := req.parse()
+		if err != nil {
+			return nil, err
+		}
+		return nil, h.AddStoredTime(req.Key, day, duration)
+	})
+
+	h.registerRPC("timer.set", func(payload json.RawMessage) (interface{}, error) {
+		var req timerEditRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return nil, err
+		}
+
+		day, duration, err := req.parse()
+		if err != nil {
+			return nil, err
+		}
+		return nil, h.SetStoredTime(req.Key, day, duration)
+	})
+
+	// Sets the notes of the running timer, or of the day when one is given
+	h.registerRPC("timer.notes", func(payload json.RawMessage) (interface{}, error) {
+		var req timerEditRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return nil, err
+		}
+
+		if req.Day == "" {
+			timer, err := h.Timers.GetByKey(req.Key)
+			if err != nil {
+				return nil, err
+			}
+			return nil, h.setTimerNotes(timer, req.Notes)
+		}
+
+		day, _, err := req.parse()
+		if err != nil {
+			return nil, err
+		}
+		return nil, h.updateStoredTimer(req.Key, day, func(timer *StoredTimer) error {
+			timer.Notes = req.Notes
+			return nil
+		})
+	})
+
+	h.registerRPC("timer.delete", func(payload json.RawMessage) (interface{}, error) {
+		var req timerEditRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return nil, err
+		}
+
+		day, _, err := req.parse()
+		if err != nil {
+			return nil, err
+		}
+		return nil, h.DeleteStoredTime(req.Key, day)
+	})
+}
+
+// AddStoredTime adds duration to the time already tracked for key on day.
+func (h *harvester) AddStoredTime(key string, day time.Time, duration time.Duration) error {
+	return h.updateStoredTimer(key, day, func(timer *StoredTimer) error {
+		return timer.setDuration(timer.Duration + duration)
+	})
+}
+
+// SetStoredTime replaces the time tracked for key on day with duration.
+func (h *harvester) SetStoredTime(key string, day time.Time, duration time.Duration) error {
+	return h.updateStoredTimer(key, day, func(timer *StoredTimer) error {
+		return timer.setDuration(duration)
+	})
+}
+
+// DeleteStoredTime clears the time tracked for key on day. The record is kept
+// with no duration so the next backfill clears the harvest entry as well.
+func (h *harvester) DeleteStoredTime(key string, day time.Time) error {
+	return h.updateStoredTimer(key, day, func(timer *StoredTimer) error {
+		return timer.setDuration(0)
+	})
+}
+
+// addNotes adds notes on a new line unless the day already has them.
+func (t *StoredTimer) addNotes(notes string) {
+	switch {
+	case notes == "" || strings.Contains(t.Notes, notes):
+	case t.Notes == "":
+		t.Notes = notes
+	default:
+		t.Notes += "\n" + notes
+	}
+}
+
+func (t *StoredTimer) setDuration(duration time.Duration) error {
+	if duration < 0 {
+		return &rpcError{Code: rpcErrBadRequest, Message: "time for a day can not be negative"}
+	}
+	if duration > maxDayDuration {
+		return &rpcError{Code: rpcErrBadRequest, Message: "time for a day can not be more than 24 hours"}
+	}
+
+	t.resize(duration, intervalSourceManual)
+	return nil
+}
+
+func (h *harvester) updateStoredTimer(key string, day time.Time, update func(*StoredTimer) error) error {
+	day = now.With(day).BeginningOfDay()
+	if day.After(time.Now()) {
+		return &rpcError{Code: rpcErrBadRequest, Message: "can not track time in the future"}
+	}
+
+	// Paused timers hold time of the key that is not stored yet
+	if timer, err := h.Timers.GetByKey(key); err == nil && (timer.Running || timer.Paused) {
+		return &rpcError{Code: rpcErrBadRequest, Message: fmt.Sprintf("stop the timer for %s before editing it", key)}
+	}
+
+	return h.modifyStoredTimer(key, day, update)
+}
+
+// storeInterval adds the interval to the stored timers of key, splitting it
+// into one interval per day when it runs past midnight. The notes are added
+// to the notes of each day.
+func (h *harvester) storeInterval(key string, interval TimeInterval, notes string) error {
+	for _, part := range splitAtMidnight(interval) {
+		day := now.With(part.Start.Local()).BeginningOfDay()
+		err := h.modifyStoredTimer(key, day, func(timer *StoredTimer) error {
+			timer.addInterval(part)
+			timer.addNotes(notes)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// modifyStoredTimer loads the stored timer of key on day, creating it if it
+// does not exist, and saves it after applying update.
+func (h *harvester) modifyStoredTimer(key string, day time.Time, update func(*StoredTimer) error) error {
+	dbKey := storedTimerKey(key, day)
+	return h.db.Update(func(txn *badger.Txn) error {
+		timer := &StoredTimer{
+			Key: key,
+			Day: day,
+		}
+
+		item, err := txn.Get(dbKey)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+		if err == nil {
+			err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, timer)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := update(timer); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(timer)
+		if err != nil {
+			return err
+		}
+		return txn.Set(dbKey, data)
+	})
+}
+
+// storedTimerKey is the database key of the stored time for key on day. Day
+// must be in local time so the key matches the day the time was tracked on.
+func storedTimerKey(key string, day time.Time) []byte {
+	return []byte(fmt.Sprintf("timer.%s.%s", key, day.Format("20060102")))
+}
@@ -0,0 +1,40 @@
+package harvester
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTimerNotesValidation(t *testing.T) {
+	h, _ := newTestHarvester(t)
+	h.registerTimerEditHandlers()
+	h.Timers = TaskTimers{{Key: "PAUSED", Paused: true}}
+
+	today := time.Now().Format("2006-01-02")
+	tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+
+	tests := []struct {
+		name string
+		req  timerEditRequest
+		ok   bool
+	}{
+		{"notes of a past day", timerEditRequest{Key: "ACME", Day: today, Notes: "Reviewed"}, true},
+		{"notes of a future day", timerEditRequest{Key: "ACME", Day: tomorrow, Notes: "Planned"}, false},
+		{"notes of a paused timer", timerEditRequest{Key: "PAUSED", Day: today, Notes: "Reviewed"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := json.Marshal(tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp := h.handleRPC(rpcRequest{Command: "timer.notes", Payload: payload})
+			if ok := resp.Error == nil; ok != tt.ok {
+				t.Errorf("accepted %t, want %t: %v", ok, tt.ok, resp.Error)
+			}
+		})
+	}
+}
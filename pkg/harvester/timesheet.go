@@ -1,101 +1,229 @@
 package harvester
 
 import (
+	"encoding/json"
+	"fmt"
+	"log"
 	"math"
 	"sort"
+	"strings"
 	"time"
 
+	"github.com/atotto/clipboard"
 	"github.com/dgraph-io/badger"
+	"github.com/jinzhu/now"
 )
 
+// maxTimesheetDays limits the days of a single timesheet, a year and a day.
+const maxTimesheetDays = 366
+
+// Timesheets list the time per key unless grouped by one of these.
+const (
+	groupByKey     = "key"
+	groupByProject = "project"
+	groupByClient  = "client"
+	groupByEpic    = "epic"
+)
+
+// TimeSheet holds the time of each day from TimeStart until TimeEnd. Days are
+// the local beginnings of the days the durations are for.
 type TimeSheet struct {
 	TimeStart time.Time      `json:"timeStart"`
 	TimeEnd   time.Time      `json:"timeEnd"`
+	Days      []time.Time    `json:"days"`
+	GroupBy   string         `json:"groupBy"`
 	Tasks     []TaskTimeInfo `json:"tasks"`
 	DaysTotal []float64      `json:"daysTotal"`
 	Total     float64        `json:"total"`
 }
+
+// TaskTimeInfo is the time of a key, or of a group and the keys in it.
 type TaskTimeInfo struct {
 	Key       string    `json:"key"`
+	Keys      []string  `json:"keys,omitempty"`
 	Durations []float64 `json:"durations"`
 	TotalTime float64   `json:"totalTime"`
+	Notes     string    `json:"notes,omitempty"`
+}
+
+// timesheetRequest asks for the timesheet of the given view relative to Start.
+// Views are day, week, month or range, ranges run from Start until End. Move
+// is one of "=" for the current period, "." to reload the period at Start, "-"
+// for the previous one or "+" for the next one.
+type timesheetRequest struct {
+	View    string    `json:"view"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Move    string    `json:"move"`
+	GroupBy string    `json:"groupBy"`
 }
 
-// viewType can be today, week, month
-func (h *harvester) getTimeSheet(startTime, endTime time.Time) (*TimeSheet, error) {
-	viewType := "day"
-	days := 1
-	if endTime.Sub(startTime).Hours() > 24 {
-		viewType = "week"
-		days = 7
+// period returns the start and end of the requested timesheet.
+func (r *timesheetRequest) period() (time.Time, time.Time, error) {
+	now.WeekStartDay = time.Monday
+
+	start := now.With(r.Start.Local()).BeginningOfDay()
+
+	var next func(t time.Time, n int) time.Time
+	switch r.View {
+	case "day":
+		next = func(t time.Time, n int) time.Time { return t.AddDate(0, 0, n) }
+	case "week":
+		next = func(t time.Time, n int) time.Time { return t.AddDate(0, 0, 7*n) }
+	case "month":
+		next = func(t time.Time, n int) time.Time { return t.AddDate(0, n, 0) }
+	case "range":
+		if r.End.Before(r.Start) {
+			return start, start, &rpcError{Code: rpcErrBadRequest, Message: "the end of the range is before its start"}
+		}
+		days := daysBetween(r.Start, r.End) + 1
+		next = func(t time.Time, n int) time.Time { return t.AddDate(0, 0, days*n) }
+	default:
+		return start, start, &rpcError{Code: rpcErrBadRequest, Message: "unknown view " + r.View}
 	}
 
-	timers, err := getTimersByOpts(h.db, badger.DefaultIteratorOptions)
-	if err != nil {
-		return nil, err
+	switch r.Move {
+	case "=":
+		switch r.View {
+		case "day":
+			start = now.BeginningOfDay()
+		case "week":
+			start = now.BeginningOfWeek()
+		case "month":
+			start = now.BeginningOfMonth()
+		}
+	case ".":
+	case "-":
+		start = next(start, -1)
+	case "+":
+		start = next(start, 1)
+	default:
+		return start, start, &rpcError{Code: rpcErrBadRequest, Message: "unknown move " + r.Move}
 	}
 
-	// Add any currently running timers that are not already stored
-CURRENT_TIMER:
-	for _, currentTimer := range h.Timers {
-		if currentTimer.StartedAt == nil {
-			continue
+	return start, next(start, 1).Add(-1 * time.Minute), nil
+}
+
+func (h *harvester) registerTimesheetHandlers() {
+	h.registerRPC("timesheet", func(payload json.RawMessage) (interface{}, error) {
+		var req timesheetRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return nil, err
+		}
+
+		start, end, err := req.period()
+		if err != nil {
+			return nil, err
+		}
+		return h.getTimeSheet(start.UTC(), end.UTC(), req.GroupBy)
+	})
+
+	// Copies all keys without a harvest project in the month of start
+	h.registerRPC("timesheet.copy", func(payload json.RawMessage) (interface{}, error) {
+		var req timesheetRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return nil, err
 		}
 
-		for _, timer := range timers {
-			if timer.Key == currentTimer.Key && timer.Day.YearDay() == currentTimer.StartedAt.YearDay() {
-				continue CURRENT_TIMER
+		start := now.With(req.Start).BeginningOfMonth()
+		end := now.With(req.Start).EndOfMonth()
+		keys, err := GetKeysWithTimes(h.db, start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		var noProjects string
+		for _, k := range keys {
+			tracker, err := h.Timers.GetByKey(k)
+			if err == nil {
+				if tracker.Project == nil && tracker.Issue != nil {
+					noProjects += fmt.Sprintf("\n%s; %s", tracker.Key, tracker.Issue.Summary)
+				}
 			}
 		}
 
-		timers = append(
-			timers,
-			StoredTimer{
-				Key:      currentTimer.Key,
-				Day:      *currentTimer.StartedAt,
-				Duration: time.Since(*currentTimer.StartedAt),
-			},
-		)
+		return nil, clipboard.WriteAll(noProjects)
+	})
+}
+
+// getTimeSheet returns the time of each day from the day of startTime until
+// the day of endTime, grouped by key unless groupBy is another grouping.
+func (h *harvester) getTimeSheet(startTime, endTime time.Time, groupBy string) (*TimeSheet, error) {
+	if groupBy == "" {
+		groupBy = groupByKey
+	}
+	switch groupBy {
+	case groupByKey, groupByProject, groupByClient, groupByEpic:
+	default:
+		return nil, &rpcError{Code: rpcErrBadRequest, Message: "unknown grouping " + groupBy}
+	}
+
+	firstDay := now.With(startTime.Local()).BeginningOfDay()
+	days := daysBetween(firstDay, endTime) + 1
+	if days > maxTimesheetDays {
+		return nil, &rpcError{Code: rpcErrBadRequest, Message: fmt.Sprintf("timesheets can cover at most %d days", maxTimesheetDays)}
+	}
+
+	dayStarts := make([]time.Time, days)
+	for i := range dayStarts {
+		dayStarts[i] = firstDay.AddDate(0, 0, i)
+	}
+
+	timers, err := getTimersByOpts(h.db, badger.DefaultIteratorOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add the time of running and paused timers to the days they ran on
+	for _, currentTimer := range h.Timers {
+		for _, running := range currentTimer.uncommitted(time.Now().UTC()) {
+			timers = timers.addRunning(currentTimer.Key, running)
+		}
 	}
 
 	var total float64
 	daysTotal := make([]float64, days)
+	lookup := &keyLookup{h: h, epics: groupBy == groupByEpic}
 
 	times := make(map[string]TaskTimeInfo, 0)
 	for _, timer := range timers {
-		// If a timer is currently running for this key then add the duration to it
-		if runningTimer, err := h.Timers.GetByKey(timer.Key); err == nil {
-			if runningTimer.StartedAt != nil && runningTimer.StartedAt.Format("20060102") == timer.Day.Format("20060102") {
-				timer.Duration = timer.Duration + time.Since(*runningTimer.StartedAt)
-			}
-		}
-
-		if timer.Day.Before(startTime) {
+		if timer.Day.Before(startTime) || timer.Duration == 0 {
 			continue
 		}
 		if timer.Day.After(endTime) {
-			break
+			continue
 		}
 
+		group := h.timesheetGroup(groupBy, timer.Key, lookup)
+
 		// Find an existing tracker, if none exists create it.
-		jiraTracker, ok := times[timer.Key]
+		jiraTracker, ok := times[group]
 		if !ok {
 			jiraTracker = TaskTimeInfo{
-				Key:       timer.Key,
+				Key:       group,
 				Durations: make([]float64, days),
 			}
 		}
+		if groupBy != groupByKey && !containsString(jiraTracker.Keys, timer.Key) {
+			jiraTracker.Keys = append(jiraTracker.Keys, timer.Key)
+		}
 
-		day := day(viewType, timer.Day.Local())
+		day := daysBetween(firstDay, timer.Day)
+		if day < 0 || day >= days {
+			continue
+		}
 		runTime := timer.Duration.Hours()
 
 		jiraTracker.Durations[day] = jiraTracker.Durations[day] + runTime
 		jiraTracker.TotalTime = jiraTracker.TotalTime + runTime
+		if timer.Notes != "" && !strings.Contains(jiraTracker.Notes, timer.Notes) {
+			jiraTracker.Notes = strings.TrimPrefix(jiraTracker.Notes+"\n"+timer.Notes, "\n")
+		}
 
 		daysTotal[day] = daysTotal[day] + runTime
 		total = total + runTime
 
-		times[timer.Key] = jiraTracker
+		times[group] = jiraTracker
 	}
 
 	// Turn map into slice and sort by the week number
@@ -107,6 +235,7 @@ CURRENT_TIMER:
 			j.Durations[i] = math.Round(j.Durations[i]*100) / 100
 		}
 
+		sort.Strings(j.Keys)
 		trackedTasks = append(trackedTasks, j)
 	}
 	sort.Slice(trackedTasks, func(a, b int) bool {
@@ -123,19 +252,135 @@ CURRENT_TIMER:
 		Total:     math.Round(total*100) / 100,
 		TimeStart: startTime,
 		TimeEnd:   endTime,
+		Days:      dayStarts,
+		GroupBy:   groupBy,
 	}, nil
 }
 
-func day(view string, date time.Time) int {
-	if view == "day" {
-		return 0
+// timesheetGroup returns the group the time of key is listed under. Keys
+// without a project, client or epic are grouped together.
+func (h *harvester) timesheetGroup(groupBy, key string, lookup *keyLookup) string {
+	if groupBy == groupByKey {
+		return key
+	}
+
+	project, issue := lookup.find(key)
+	switch groupBy {
+	case groupByProject:
+		if project != nil {
+			return project.Name
+		}
+		return "No project"
+	case groupByClient:
+		if project != nil && project.Client != "" {
+			return project.Client
+		}
+		return "No client"
+	case groupByEpic:
+		if issue != nil && issue.Epic != "" {
+			return issue.Epic
+		}
+		return "No epic"
+	}
+	return key
+}
+
+// keyLookup finds the project and issue of keys tracked in the past. Keys
+// without a timer, like those of issues closed since, are looked up in the
+// backend and the tracker once per timesheet.
+type keyLookup struct {
+	h *harvester
+
+	// epics has issues looked up as well, only grouping by epic needs them
+	epics    bool
+	projects Projects
+	issues   map[string]*Issue
+}
+
+func (l *keyLookup) find(key string) (*Project, *Issue) {
+	var project *Project
+	var issue *Issue
+	if timer, err := l.h.Timers.GetByKey(key); err == nil {
+		project, issue = timer.Project, timer.Issue
 	}
 
-	// Shift the days back 1 so that the week starts on Monday
-	day := int(date.Weekday()) - 1
-	if day < 0 {
-		day = 6
+	if project == nil {
+		project = l.project(key)
 	}
+	if issue == nil && l.epics {
+		issue = l.issue(key)
+	}
+	return project, issue
+}
 
-	return day
+func (l *keyLookup) project(key string) *Project {
+	if l.projects == nil {
+		l.projects = Projects{}
+		if l.h.backend != nil {
+			projects, err := l.h.getIncludedProjects()
+			if err != nil {
+				log.Println(err)
+			}
+			l.projects = append(l.projects, projects...)
+		}
+	}
+
+	project, _ := l.projects.getByKey(key)
+	return project
+}
+
+func (l *keyLookup) issue(key string) *Issue {
+	if l.issues == nil {
+		l.issues = make(map[string]*Issue)
+	}
+	if issue, ok := l.issues[key]; ok {
+		return issue
+	}
+
+	issue, err := l.h.getIssue(key)
+	if err != nil {
+		issue = nil
+	}
+	l.issues[key] = issue
+	return issue
+}
+
+// daysBetween counts the calendar days from the day of a until the day of b.
+func daysBetween(a, b time.Time) int {
+	a = now.With(a.Local()).BeginningOfDay()
+	b = now.With(b.Local()).BeginningOfDay()
+
+	// Rounding keeps days with daylight saving changes whole
+	return int(math.Round(b.Sub(a).Hours() / 24))
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// addRunning adds the running interval of key to the stored timers of each day
+// it covers, adding new timers for days without any stored time.
+func (timers StoredTimers) addRunning(key string, running TimeInterval) StoredTimers {
+PART:
+	for _, part := range splitAtMidnight(running) {
+		day := now.With(part.Start.Local()).BeginningOfDay()
+		for i := range timers {
+			if timers[i].Key == key && timers[i].Day.Equal(day) {
+				timers[i].Duration += part.Duration()
+				continue PART
+			}
+		}
+
+		timers = append(timers, StoredTimer{
+			Key:      key,
+			Day:      day,
+			Duration: part.Duration(),
+		})
+	}
+	return timers
 }
@@ -2,15 +2,9 @@ package harvester
 
 import (
 	"encoding/json"
-	"fmt"
-	"log"
-	"strings"
-	"time"
 
 	"github.com/asticode/go-astilectron"
 	astiptr "github.com/asticode/go-astitools/ptr"
-	"github.com/atotto/clipboard"
-	"github.com/jinzhu/now"
 	"github.com/skratchdot/open-golang/open"
 )
 
@@ -18,14 +12,18 @@ type Window struct {
 	*astilectron.Window
 	View              string
 	PreviousTimerSize int
-	CurrentData       *AppData
 }
 
 type AppData struct {
-	View     string     `json:"view"`
-	Timers   TaskTimers `json:"timers"`
-	Settings *Settings  `json:"settings"`
-	Error    string     `json:"error"`
+	View       string           `json:"view"`
+	Timers     TaskTimers       `json:"timers"`
+	Settings   *Settings        `json:"settings"`
+	Recovery   []activeTimer    `json:"recovery"`
+	Idle       *idlePeriod      `json:"idle"`
+	Keys       []string         `json:"keys"`
+	Pending    int              `json:"pending"`
+	Backfill   []backfillChange `json:"backfill"`
+	BackfillID string           `json:"backfillId"`
 }
 
 func (h *harvester) createWindow() error {
@@ -48,6 +46,12 @@ func (h *harvester) createWindow() error {
 			Width:           astiptr.Int(350),
 			MinWidth:        astiptr.Int(300),
 			BackgroundColor: astiptr.Str("#1A1D21"),
+			WebPreferences: &astilectron.WebPreferences{
+				// The page gets what it needs from electron through the preload
+				NodeIntegration:  astiptr.Bool(false),
+				ContextIsolation: astiptr.Bool(true),
+				Preload:          astiptr.Str(h.preloadPath),
+			},
 		},
 	)
 	if err != nil {
@@ -74,10 +78,18 @@ func (h *harvester) renderMainWindow() error {
 			return err
 		}
 
-		ready := make(chan bool)
+		// Reloads of the page send ready again, only the first one is waited for
+		ready := make(chan bool, 1)
 		h.mainListener(ready)
 		go func() {
 			<-ready
+
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if len(h.recovery) > 0 {
+				h.renderRecovery()
+				return
+			}
 			h.mainWindow.sendMessage(&AppData{View: "main"})
 		}()
 	} else {
@@ -108,6 +120,36 @@ func (h *harvester) renderSettings() error {
 	return h.mainWindow.sendMessage(&AppData{View: "settings", Settings: h.Settings})
 }
 
+// renderRecovery asks what to do with timers left running by a previous run.
+func (h *harvester) renderRecovery() error {
+	h.mainWindow.SetBounds(astilectron.RectangleOptions{
+		SizeOptions: astilectron.SizeOptions{
+			Height: astiptr.Int(100 + len(h.recovery)*90),
+			Width:  astiptr.Int(430),
+		},
+	})
+	return h.mainWindow.sendMessage(&AppData{View: "recover", Recovery: h.recovery})
+}
+
+// renderIdle asks what to do with time tracked while the user was away.
+func (h *harvester) renderIdle() error {
+	h.mainWindow.SetBounds(astilectron.RectangleOptions{
+		SizeOptions: astilectron.SizeOptions{
+			Height: astiptr.Int(220),
+			Width:  astiptr.Int(430),
+		},
+	})
+	// Offer the other timers to move the idle time to
+	var keys []string
+	for _, t := range h.Timers {
+		if !containsString(h.idle.Keys, t.Key) {
+			keys = append(keys, t.Key)
+		}
+	}
+
+	return h.mainWindow.sendMessage(&AppData{View: "idle", Idle: h.idle, Keys: keys})
+}
+
 func (h *harvester) renderTimesheet() error {
 	h.mainWindow.SetBounds(astilectron.RectangleOptions{
 		SizeOptions: astilectron.SizeOptions{
@@ -120,183 +162,130 @@ func (h *harvester) renderTimesheet() error {
 
 }
 
-func (h *harvester) mainListener(ready chan bool) {
-	h.mainWindow.OnMessage(func(m *astilectron.EventMessage) interface{} {
-		var data string
-		if err := m.Unmarshal(&data); err != nil {
-			h.sendErr(err)
-			return err
-		}
+// renderBackfill lists the planned harvest changes for approval.
+func (h *harvester) renderBackfill() error {
+	h.mainWindow.SetBounds(astilectron.RectangleOptions{
+		SizeOptions: astilectron.SizeOptions{
+			Height: astiptr.Int(400),
+			Width:  astiptr.Int(630),
+		},
+	})
 
-		switch {
-		case data == "ready":
-			ready <- true
-		case data == "copy":
-		case data == "refresh":
-			if err := h.Refresh(); err != nil {
-				h.sendErr(err)
-				return err
-			}
-		case data == "timesheet":
-			var err error
-			if h.mainWindow.View == "timesheet" {
-				err = h.renderMainWindow()
-			} else {
-				err = h.renderTimesheet()
-			}
-			if err != nil {
-				h.sendErr(err)
-				return err
-			}
-		case data == "settings":
-			var err error
-			if h.mainWindow.View == "settings" {
-				err = h.renderMainWindow()
-			} else {
-				err = h.renderSettings()
-			}
-			if err != nil {
-				h.sendErr(err)
-				return err
-			}
-		case data == "harvest":
-			if h.harvestURL != nil {
-				if err := open.Run(h.harvestURL.String()); err != nil {
-					h.sendErr(err)
-					return err
-				}
-			}
-		case strings.HasPrefix(data, "settings|"):
-			data = strings.TrimPrefix(data, "settings|")
+	return h.mainWindow.sendMessage(&AppData{
+		View:       "backfill",
+		Backfill:   h.pendingBackfill,
+		BackfillID: backfillPlanID(h.pendingBackfill),
+		Pending:    len(h.pendingBackfill),
+	})
+}
 
-			var settings Settings
-			if err := json.Unmarshal([]byte(data), &settings); err != nil {
-				h.sendErr(err)
-				return err
-			}
+type viewRequest struct {
+	Name string `json:"name"`
+}
 
-			if h.Settings == nil {
-				h.Settings = &Settings{}
-			}
+func (h *harvester) registerWindowHandlers(ready chan bool) {
+	h.registerRPC("ready", func(json.RawMessage) (interface{}, error) {
+		select {
+		case ready <- true:
+		default:
+		}
+		return nil, nil
+	})
 
-			h.Settings.Jira = SettingsData{
-				URL:  settings.Jira.URL,
-				User: settings.Jira.User,
-				Pass: settings.Jira.Pass,
-			}
+	h.registerRPC("refresh", func(json.RawMessage) (interface{}, error) {
+		return nil, h.Refresh()
+	})
 
-			h.Settings.Harvest = SettingsData{
-				User: settings.Harvest.User,
-				Pass: settings.Harvest.Pass,
-			}
+	// Toggles between the requested view and the main timers view
+	h.registerRPC("view", func(payload json.RawMessage) (interface{}, error) {
+		var req viewRequest
+		if err := decodePayload(payload, &req); err != nil {
+			return nil, err
+		}
 
-			h.changeCh <- true
+		if h.mainWindow.View == req.Name {
+			return nil, h.renderMainWindow()
+		}
 
-			h.renderMainWindow()
-		case strings.HasPrefix(data, "day|") || strings.HasPrefix(data, "week|"):
-			now.WeekStartDay = time.Monday
+		switch req.Name {
+		case "main":
+			return nil, h.renderMainWindow()
+		case "timesheet":
+			return nil, h.renderTimesheet()
+		case "settings":
+			return nil, h.renderSettings()
+		case "backfill":
+			return nil, h.renderBackfill()
+		}
+		return nil, &rpcError{Code: rpcErrBadRequest, Message: "unknown view " + req.Name}
+	})
 
-			parts := strings.Split(data, "|")
-			if len(parts) < 3 {
-				return nil
-			}
+	h.registerRPC("harvest.open", func(json.RawMessage) (interface{}, error) {
+		if h.backendURL == nil {
+			return nil, nil
+		}
+		return nil, open.Run(h.backendURL.String())
+	})
 
-			var startTime time.Time
-			var err error
-			if parts[2] != "=" {
-				startTime, err = time.Parse("2006-01-02T15:04:05Z", parts[1])
-				if err != nil {
-					h.sendErr(err)
-					return nil
-				}
-			}
+	h.registerRPC("settings.save", func(payload json.RawMessage) (interface{}, error) {
+		var settings Settings
+		if err := decodePayload(payload, &settings); err != nil {
+			return nil, err
+		}
 
-			addDuration := 24 * time.Hour
-			if parts[0] == "week" {
-				addDuration = 24 * 7 * time.Hour
-			}
+		if h.Settings == nil {
+			h.Settings = &Settings{}
+		}
 
-			var start, end time.Time
-
-			switch parts[2] {
-			case "=":
-				start = now.BeginningOfDay()
-				if parts[0] == "week" {
-					start = now.BeginningOfWeek()
-				}
-			case "-":
-				start = startTime.Add(-addDuration)
-			case "+":
-				start = startTime.Add(addDuration)
-			case "copy":
-				start = now.With(startTime).BeginningOfMonth()
-				end = now.With(startTime).EndOfMonth()
-				keys, err := GetKeysWithTimes(h.db, start, end)
-				if err != nil {
-					h.sendErr(err)
-					return nil
-				}
-
-				var noProjects string
-				for _, k := range keys {
-					tracker, err := h.Timers.GetByKey(k)
-					if err == nil {
-						if tracker.Harvest == nil {
-							noProjects += fmt.Sprintf("\n%s; %s", tracker.Key, tracker.Jira.Fields.Summary)
-						}
-					}
-				}
-
-				clipboard.WriteAll(noProjects)
-				return nil
-			}
+		jiraSettings := SettingsData{
+			URL:          settings.Jira.URL,
+			User:         settings.Jira.User,
+			Pass:         settings.Jira.Pass,
+			Auth:         settings.Jira.Auth,
+			ClientID:     settings.Jira.ClientID,
+			ClientSecret: settings.Jira.ClientSecret,
+		}
 
-			end = start.Add(addDuration - 1*time.Minute)
+		// Keep the oauth login unless the site or the app it was made with changed
+		if jiraSettings.URL == h.Settings.Jira.URL && jiraSettings.Auth == h.Settings.Jira.Auth && jiraSettings.ClientID == h.Settings.Jira.ClientID {
+			jiraSettings.Token = h.Settings.Jira.Token
+			jiraSettings.APIURL = h.Settings.Jira.APIURL
+		}
+		h.Settings.Jira = jiraSettings
+
+		harvestSettings := SettingsData{
+			User:         settings.Harvest.User,
+			Pass:         settings.Harvest.Pass,
+			Auth:         settings.Harvest.Auth,
+			ClientID:     settings.Harvest.ClientID,
+			ClientSecret: settings.Harvest.ClientSecret,
+		}
 
-			timesheet, err := h.getTimeSheet(start.UTC(), end.UTC())
-			if err != nil {
-				h.sendErr(err)
-				return nil
-			}
-			return timesheet
-		case strings.Contains(data, "|"):
-			parts := strings.Split(data, "|")
-			task, err := h.Timers.GetByKey(parts[1])
-			if err != nil {
-				h.sendErr(err)
-				return err
-			}
+		// Keep the oauth login unless the app it was made with changed
+		if harvestSettings.Auth == h.Settings.Harvest.Auth && harvestSettings.ClientID == h.Settings.Harvest.ClientID {
+			harvestSettings.Token = h.Settings.Harvest.Token
+		}
+		h.Settings.Harvest = harvestSettings
 
-			switch parts[0] {
-			case "start":
-				err = h.StartTimer(task)
-			case "stop":
-				err = h.StopTimer(task)
-			case "open":
-				err = open.Run(h.Settings.Jira.URL + "/browse/" + parts[1])
-			}
-			if err != nil {
-				h.sendErr(err)
-				return err
-			}
+		h.Settings.JiraQueries = settings.JiraQueries
+		h.Settings.ExcludedProjects = settings.ExcludedProjects
+		h.Settings.IdleMinutes = settings.IdleMinutes
+		h.Settings.TimerMode = settings.TimerMode
+		h.Settings.SyncWeeks = settings.SyncWeeks
+		h.Settings.SyncStrategy = settings.SyncStrategy
+		h.Settings.JiraWorklogs = settings.JiraWorklogs
 
-			h.sendTimers(false, false)
-		default:
-			log.Println("unknown rpc handler " + data)
-		}
+		h.settingsChanged()
 
-		return nil
+		return nil, h.renderMainWindow()
 	})
 }
 
-func (h *harvester) sendErr(err error) {
-	fmt.Println(err)
-	current := *h.mainWindow.CurrentData
-	current.Error = err.Error()
-	h.mainWindow.SendMessage(current)
-}
-
 func (h *harvester) sendTimers(auto, force bool) {
+	if h.mainWindow == nil {
+		return
+	}
+
 	if !force && h.mainWindow.View != "main" {
 		return
 	}
@@ -306,7 +295,7 @@ func (h *harvester) sendTimers(auto, force bool) {
 		t.Runtime = t.CurrentRuntime()
 	}
 
-	h.mainWindow.sendMessage(&AppData{View: "main", Timers: h.Timers})
+	h.mainWindow.sendMessage(&AppData{View: "main", Timers: h.Timers, Pending: len(h.pendingBackfill)})
 
 	// Change the height of the window to match the number of timers
 	if auto {
@@ -326,6 +315,5 @@ func (h *harvester) sendTimers(auto, force bool) {
 
 func (w *Window) sendMessage(message *AppData) error {
 	w.View = message.View
-	w.CurrentData = message
 	return w.SendMessage(message)
 }
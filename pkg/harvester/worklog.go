@@ -0,0 +1,188 @@
+package harvester
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/jinzhu/now"
+)
+
+const worklogPrefix = "worklog."
+
+var (
+	// errNotAnIssue is returned for keys work can not be logged against
+	errNotAnIssue = errors.New("key is not an issue")
+
+	// errWorklogMissing is returned when a logged worklog has been deleted
+	errWorklogMissing = errors.New("worklog no longer exists")
+)
+
+// worklogger is implemented by trackers able to log time against their
+// issues. Keys are the keys within the tracker.
+type worklogger interface {
+	AddWorklog(key string, started time.Time, duration time.Duration, comment string) (string, error)
+	UpdateWorklog(key, id string, started time.Time, duration time.Duration, comment string) error
+	DeleteWorklog(key, id string) error
+}
+
+// loggedWork is the worklog created for the time of a key on a day, kept so
+// syncing again updates it instead of adding another.
+type loggedWork struct {
+	ID       string        `json:"id"`
+	Duration time.Duration `json:"duration"`
+	Comment  string        `json:"comment,omitempty"`
+}
+
+// worklogKey is the database key of the worklog for the time of key on day.
+func worklogKey(key string, day time.Time) []byte {
+	return []byte(fmt.Sprintf("%s%s.%s", worklogPrefix, key, day.Format("20060102")))
+}
+
+// syncWorklogs logs the stored time of the synced weeks as worklogs of the
+// issues, updating the worklogs of days that changed since the last sync.
+// Days before worklogs were turned on are never logged.
+func (h *harvester) syncWorklogs() error {
+	if !h.Settings.JiraWorklogs {
+		return nil
+	}
+
+	// Worklogs turned on before the day was recorded start from now
+	if h.Settings.trackWorklogsSince() {
+		if err := h.Settings.Save(h.db, h.secrets); err != nil {
+			return err
+		}
+	}
+
+	from := now.BeginningOfDay().AddDate(0, 0, -7*h.Settings.syncWeeks())
+	if h.Settings.JiraWorklogsSince.After(from) {
+		from = *h.Settings.JiraWorklogsSince
+	}
+
+	storedTimers, err := getTimersByOpts(h.db, badger.DefaultIteratorOptions)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, timer := range storedTimers {
+		if timer.Day.Before(from) {
+			continue
+		}
+
+		name, key := splitKey(timer.Key)
+		tracker, err := h.getTracker(name)
+		if err != nil {
+			continue
+		}
+		logger, ok := tracker.(worklogger)
+		if !ok {
+			continue
+		}
+
+		if err := h.syncWorklog(logger, key, timer); err != nil {
+			log.Printf("unable to log work for %s: %s", timer.Key, err)
+			failed = append(failed, timer.Key)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to log work for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (h *harvester) syncWorklog(logger worklogger, key string, timer StoredTimer) error {
+	dbKey := worklogKey(timer.Key, timer.Day)
+
+	logged, err := getLoggedWork(h.db, dbKey)
+	if err != nil {
+		return err
+	}
+
+	// Worklogs are kept to the minute, the smallest time jira accepts
+	duration := timer.Duration.Round(time.Minute)
+	if logged != nil && logged.Duration == duration && logged.Comment == timer.Notes {
+		return nil
+	}
+
+	if duration == 0 {
+		if logged == nil {
+			return nil
+		}
+
+		err := logger.DeleteWorklog(key, logged.ID)
+		if err != nil && err != errWorklogMissing {
+			return err
+		}
+		return h.db.Update(func(txn *badger.Txn) error {
+			return txn.Delete(dbKey)
+		})
+	}
+
+	started := timer.Day
+	if len(timer.Intervals) > 0 {
+		started = timer.Intervals[0].Start.Local()
+	}
+
+	if logged != nil {
+		err := logger.UpdateWorklog(key, logged.ID, started, duration, timer.Notes)
+		if err == nil {
+			logged.Duration = duration
+			logged.Comment = timer.Notes
+			return saveLoggedWork(h.db, dbKey, logged)
+		}
+		if err != errWorklogMissing {
+			return err
+		}
+	}
+
+	id, err := logger.AddWorklog(key, started, duration, timer.Notes)
+	if err == errNotAnIssue {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return saveLoggedWork(h.db, dbKey, &loggedWork{
+		ID:       id,
+		Duration: duration,
+		Comment:  timer.Notes,
+	})
+}
+
+// getLoggedWork returns the worklog saved under dbKey, nil when there is none.
+func getLoggedWork(db *badger.DB, dbKey []byte) (*loggedWork, error) {
+	var logged *loggedWork
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(dbKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			logged = &loggedWork{}
+			return json.Unmarshal(val, logged)
+		})
+	})
+	return logged, err
+}
+
+func saveLoggedWork(db *badger.DB, dbKey []byte, logged *loggedWork) error {
+	data, err := json.Marshal(logged)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(txn *badger.Txn) error {
+		return txn.Set(dbKey, data)
+	})
+}
@@ -5,6 +5,10 @@ import { Toolbar } from './toolbar';
 import { Timers } from './timers';
 import { TimeSheet } from './timesheet';
 import { Settings } from './settings';
+import { Recovery } from './recovery';
+import { Idle } from './idle';
+import { Backfill } from './backfill';
+import { watchPower } from './power';
 
 class App extends React.Component {
     render() {
@@ -15,6 +19,9 @@ class App extends React.Component {
                 {appData.data.timers && <Timers />}
                 {appData.data.view === 'timesheet' && <TimeSheet />}
                 {appData.data.view === 'settings' && <Settings />}
+                {appData.data.view === 'recover' && <Recovery />}
+                {appData.data.view === 'idle' && <Idle />}
+                {appData.data.view === 'backfill' && <Backfill />}
             </div>
         );
     }
@@ -23,3 +30,4 @@ class App extends React.Component {
 const render = () => ReactDOM.render(<App />, document.getElementById('app'));
 appData.render = render;
 render();
+watchPower();
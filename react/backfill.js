@@ -0,0 +1,151 @@
+import React from 'react';
+import Moment from 'react-moment';
+import { call } from './rpc';
+
+export class Backfill extends React.Component {
+    constructor(props) {
+        super(props);
+
+        this.state = {
+            activeView: 'plan',
+            log: [],
+        };
+
+        this.showLog = this.showLog.bind(this);
+    }
+
+    plan() {
+        call('backfill.plan');
+    }
+
+    apply() {
+        call('backfill.apply', { planId: appData.data.backfillId });
+    }
+
+    dismiss() {
+        call('backfill.dismiss');
+    }
+
+    resolve(index, use) {
+        call('backfill.resolve', { planId: appData.data.backfillId, index: index, use: use });
+    }
+
+    showLog() {
+        call('backfill.log', {}, (log) => this.setState({ activeView: 'log', log: log }));
+    }
+
+    conflict(index) {
+        return (
+            <div className="btn-group btn-group-sm" role="group">
+                <button type="button" className="btn btn-sm btn-dark" onClick={() => this.resolve(index, 'local')}>Keep local</button>
+                <button type="button" className="btn btn-sm btn-dark" onClick={() => this.resolve(index, 'remote')}>Use harvest</button>
+            </div>
+        );
+    }
+
+    changes() {
+        const changes = appData.data.backfill || [];
+        if (!changes.length) {
+            return <p>Harvest matches the local timers.</p>;
+        }
+
+        return (
+            <div>
+                <table className="time-table">
+                    <thead>
+                        <tr>
+                            <td>Jira</td>
+                            <td>Day</td>
+                            <td></td>
+                            <td align="right">Harvest</td>
+                            <td align="right">Local</td>
+                        </tr>
+                    </thead>
+                    <tbody>
+                        {changes.map((change, i) => {
+                            return (
+                                <tr key={i}>
+                                    <td>{change.key}</td>
+                                    <td><Moment format="ddd MMM Do" date={change.day} /></td>
+                                    <td>{change.action === 'conflict' ? this.conflict(i) : change.action}</td>
+                                    <td align="right">{change.action === 'create' ? '' : change.remoteHours.toFixed(2)}</td>
+                                    <td align="right">{change.localHours.toFixed(2)}</td>
+                                </tr>
+                            );
+                        })}
+                    </tbody>
+                </table>
+                <div className="btn-group btn-group-sm backfill-buttons" role="group">
+                    <button type="button" className="btn btn-sm btn-dark" onClick={this.apply}>Apply</button>
+                    <button type="button" className="btn btn-sm btn-dark" onClick={this.dismiss}>Dismiss</button>
+                </div>
+            </div>
+        );
+    }
+
+    log() {
+        if (!this.state.log.length) {
+            return <p>No changes have been applied yet.</p>;
+        }
+
+        return (
+            <table className="time-table">
+                <thead>
+                    <tr>
+                        <td>Applied</td>
+                        <td>Jira</td>
+                        <td>Day</td>
+                        <td></td>
+                        <td align="right">Hours</td>
+                    </tr>
+                </thead>
+                <tbody>
+                    {this.state.log.map((entry, i) => {
+                        return (
+                            <tr key={i} className={entry.error ? 'backfill-failed' : ''} title={entry.error}>
+                                <td><Moment format="MMM Do HH:mm" date={entry.appliedAt} /></td>
+                                <td>{entry.change.key}</td>
+                                <td><Moment format="MMM Do" date={entry.change.day} /></td>
+                                <td>{entry.change.action}</td>
+                                <td align="right">{(entry.change.action === 'import' ? entry.change.remoteHours : entry.change.localHours).toFixed(2)}</td>
+                            </tr>
+                        );
+                    })}
+                </tbody>
+            </table>
+        );
+    }
+
+    render() {
+        return (
+            <div id="backfill" className="container-fluid">
+                <div className="row">
+                    <div className="p-2">
+                        <div className="btn-group btn-group-sm" role="group">
+                            <button
+                                type="button"
+                                className={"btn btn-sm " + (this.state.activeView === 'plan' ? 'btn-secondary' : 'btn-dark')}
+                                onClick={() => this.setState({ activeView: 'plan' })}
+                            >
+                                plan
+                            </button>
+                            <button
+                                type="button"
+                                className={"btn btn-sm " + (this.state.activeView === 'log' ? 'btn-secondary' : 'btn-dark')}
+                                onClick={this.showLog}
+                            >
+                                log
+                            </button>
+                        </div>
+                    </div>
+                    <div className="col">&nbsp;</div>
+                    <div className="p-2">
+                        <img onClick={this.plan} src="/img/icons/refresh.png" height="20px" />
+                    </div>
+                </div>
+
+                {this.state.activeView === 'plan' ? this.changes() : this.log()}
+            </div>
+        );
+    }
+}
@@ -0,0 +1,108 @@
+import React from 'react';
+import { call } from './rpc';
+
+// CustomTasks manages local tasks that are not jira issues or harvest
+// projects, optionally logging their time to a harvest project and task.
+export class CustomTasks extends React.Component {
+    constructor(props) {
+        super(props);
+
+        this.state = {
+            tasks: [],
+            projects: [],
+        };
+
+        this.addTask = this.addTask.bind(this);
+    }
+
+    componentDidMount() {
+        this.load();
+    }
+
+    load() {
+        call('task.list', null, (data) => {
+            this.setState({
+                tasks: (data.tasks || []).map((t) => Object.assign({ saved: true }, t)),
+                projects: data.projects || [],
+            });
+        });
+    }
+
+    setTask(i, field, value) {
+        const tasks = this.state.tasks;
+        tasks[i][field] = (field === 'projectId' || field === 'taskId') ? (parseInt(value, 10) || 0) : value;
+
+        // Pick the first task of a newly chosen project
+        if (field === 'projectId') {
+            const tasksOf = this.tasks(tasks[i].projectId);
+            tasks[i].taskId = tasksOf.length ? tasksOf[0].id : 0;
+        }
+        this.setState({ tasks: tasks });
+    }
+
+    addTask() {
+        const tasks = this.state.tasks;
+        tasks.push({ key: '', title: '', projectId: 0, taskId: 0, color: '#6c757d', saved: false });
+        this.setState({ tasks: tasks });
+    }
+
+    saveTask(task) {
+        call('task.save', {
+            key: task.key,
+            title: task.title,
+            projectId: task.projectId,
+            taskId: task.taskId,
+            color: task.color,
+        }, () => this.load());
+    }
+
+    removeTask(i) {
+        const task = this.state.tasks[i];
+        if (!task.saved) {
+            const tasks = this.state.tasks;
+            tasks.splice(i, 1);
+            this.setState({ tasks: tasks });
+            return;
+        }
+        call('task.delete', { key: task.key }, () => this.load());
+    }
+
+    tasks(projectId) {
+        const project = this.state.projects.find((p) => p.id === projectId);
+        return project ? project.tasks || [] : [];
+    }
+
+    render() {
+        return (
+            <div>
+                <h5>Custom Tasks</h5>
+                {this.state.tasks.map((task, i) => {
+                    return (
+                        <div key={i} className="form-inline custom-task">
+                            <input type="color" className="form-control form-control-sm" value={task.color || '#6c757d'} onChange={(e) => this.setTask(i, 'color', e.target.value)} />
+                            <input className="form-control form-control-sm" placeholder="KEY" value={task.key} disabled={task.saved} onChange={(e) => this.setTask(i, 'key', e.target.value)} />
+                            <input className="form-control form-control-sm" placeholder="title" value={task.title} onChange={(e) => this.setTask(i, 'title', e.target.value)} />
+                            <select className="form-control form-control-sm" value={task.projectId || ''} onChange={(e) => this.setTask(i, 'projectId', e.target.value)}>
+                                <option value="">Local only</option>
+                                {this.state.projects.map((p) => <option key={p.id} value={p.id}>{p.code || p.name}</option>)}
+                            </select>
+                            {task.projectId > 0 && (
+                                <select className="form-control form-control-sm" value={task.taskId} onChange={(e) => this.setTask(i, 'taskId', e.target.value)}>
+                                    {this.tasks(task.projectId).map((t) => <option key={t.id} value={t.id}>{t.name}</option>)}
+                                </select>
+                            )}
+                            <button type="button" className="btn btn-sm btn-dark" onClick={() => this.saveTask(task)}>Save</button>
+                            <img onClick={() => this.removeTask(i)} src="/img/icons/close.png" height="16px" />
+                        </div>
+                    );
+                })}
+
+                <div className="btn-group btn-group-sm" role="group">
+                    <button type="button" className="btn btn-sm btn-dark" onClick={this.addTask}>Add task</button>
+                </div>
+                <br />
+                <br />
+            </div>
+        );
+    }
+}
@@ -0,0 +1,24 @@
+import { call } from './rpc';
+
+// exportTimesheet saves the timesheet of the request to a file chosen in a
+// save dialog. Pdfs are printed from the html report by the preload script.
+export function exportTimesheet(request, format) {
+    if (!window.harvester) {
+        return;
+    }
+
+    const payload = Object.assign({}, request, { format: format === 'pdf' ? 'html' : format });
+    call('timesheet.export', payload, (data) => {
+        const name = data.name.replace(/\.html$/, '.' + format);
+        window.harvester.saveFile(name, data.content, format, showError);
+    });
+}
+
+function showError(err) {
+    if (!err) {
+        return;
+    }
+
+    appData.data.error = 'unable to export the timesheet: ' + err.message;
+    appData.render();
+}
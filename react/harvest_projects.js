@@ -0,0 +1,58 @@
+import React from 'react';
+import { call } from './rpc';
+
+// HarvestProjects lists every harvest project the user is assigned to so
+// projects can be hidden from the timers.
+export class HarvestProjects extends React.Component {
+    constructor(props) {
+        super(props);
+
+        this.state = { projects: [] };
+    }
+
+    componentDidMount() {
+        call('harvest.projects', null, (projects) => this.setState({ projects: projects }));
+    }
+
+    render() {
+        if (!this.state.projects.length) {
+            return <></>;
+        }
+
+        return (
+            <div id="harvestProjects">
+                <h5>Harvest Projects</h5>
+                {this.state.projects.map((project) => {
+                    return (
+                        <div key={project.id} className="form-check">
+                            <input
+                                type="checkbox"
+                                className="form-check-input harvest-project"
+                                id={'harvestProject' + project.id}
+                                data-id={project.id}
+                                defaultChecked={!project.excluded}
+                                disabled={!project.code}
+                            />
+                            <label className="form-check-label" htmlFor={'harvestProject' + project.id}>
+                                {project.code || '(no code)'}: {project.name} {project.client && <small className="text-muted">{project.client}</small>}
+                            </label>
+                        </div>
+                    );
+                })}
+                <br />
+            </div>
+        );
+    }
+}
+
+// excludedProjects returns the ids of unchecked projects, or the current
+// setting if the project list was never loaded.
+export function excludedProjects(current) {
+    if (!document.getElementById('harvestProjects')) {
+        return current || [];
+    }
+
+    return Array.from(document.querySelectorAll('.harvest-project'))
+        .filter((input) => !input.checked)
+        .map((input) => parseInt(input.dataset.id, 10));
+}
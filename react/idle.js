@@ -0,0 +1,33 @@
+import React from 'react';
+import Moment from 'react-moment';
+import { call } from './rpc';
+
+export class Idle extends React.Component {
+    resolve(action) {
+        const key = action === 'reassign' ? document.getElementById('idleReassignKey').value : '';
+        call('idle.resolve', { action: action, key: key });
+    }
+
+    render() {
+        const idle = appData.data.idle;
+        const keys = appData.data.keys || [];
+
+        return (
+            <div id="idle-container" className="container-fluid">
+                <p>
+                    You were away from <Moment format="HH:mm" date={idle.start} /> to <Moment format="HH:mm" date={idle.end} /> while <b>{idle.keys.join(', ')}</b> {idle.keys.length > 1 ? 'were' : 'was'} running.
+                </p>
+                <div className="btn-group btn-group-sm" role="group">
+                    <button type="button" className="btn btn-sm btn-dark" onClick={() => this.resolve('keep')}>Keep</button>
+                    <button type="button" className="btn btn-sm btn-dark" onClick={() => this.resolve('discard')}>Discard</button>
+                </div>
+                <div className="form-inline idle-reassign">
+                    <select id="idleReassignKey" className="form-control form-control-sm">
+                        {keys.map((key) => <option key={key} value={key}>{key}</option>)}
+                    </select>
+                    <button type="button" className="btn btn-sm btn-dark" onClick={() => this.resolve('reassign')}>Move to</button>
+                </div>
+            </div>
+        );
+    }
+}
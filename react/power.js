@@ -0,0 +1,18 @@
+import { call } from './rpc';
+
+// watchPower reports the system idle time and sleep events from electron's
+// powerMonitor, handed to the page by the preload script, so the backend can
+// ask what to do with time spent away.
+export function watchPower() {
+    if (!window.harvester) {
+        window.addEventListener('harvester-ready', watchPower, { once: true });
+        return;
+    }
+
+    window.harvester.onSuspend(() => call('system.suspend'));
+    window.harvester.onResume(() => call('system.resume'));
+
+    setInterval(() => {
+        window.harvester.systemIdleTime((idleSeconds) => call('system.idle', { idleSeconds: idleSeconds }));
+    }, 30 * 1000);
+}
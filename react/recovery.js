@@ -0,0 +1,32 @@
+import React from 'react';
+import Moment from 'react-moment';
+import { call } from './rpc';
+
+export class Recovery extends React.Component {
+    recover(key, action) {
+        call('timer.recover', { key: key, action: action });
+    }
+
+    render() {
+        return (
+            <div id="recovery-container" className="container-fluid">
+                <p>These timers were still running when harvester last exited.</p>
+                {appData.data.recovery.map((timer) => {
+                    return (
+                        <div key={timer.key} className="recovery-timer">
+                            <div>
+                                <b>{timer.key}</b> started <Moment format="MMM Do HH:mm" date={timer.startedAt} />,
+                                last seen <Moment format="MMM Do HH:mm" date={timer.lastHeartbeat} />
+                            </div>
+                            <div className="btn-group btn-group-sm" role="group">
+                                <button type="button" className="btn btn-sm btn-dark" onClick={() => this.recover(timer.key, 'resume')}>Resume</button>
+                                <button type="button" className="btn btn-sm btn-dark" onClick={() => this.recover(timer.key, 'stop')}>Stop at last seen</button>
+                                <button type="button" className="btn btn-sm btn-dark" onClick={() => this.recover(timer.key, 'discard')}>Discard</button>
+                            </div>
+                        </div>
+                    );
+                })}
+            </div>
+        );
+    }
+}
@@ -0,0 +1,27 @@
+let nextId = 0;
+
+// call sends a typed request to the backend. Errors are shown in the error
+// banner and the callback only receives the payload of successful calls.
+export function call(command, payload, callback) {
+    const request = {
+        id: String(++nextId),
+        command: command,
+        payload: payload,
+    };
+
+    astilectron.sendMessage(request, function (response) {
+        if (response === undefined) {
+            return;
+        }
+
+        if (response.error) {
+            appData.data.error = response.error.message;
+            appData.render();
+            return;
+        }
+
+        if (callback) {
+            callback(response.payload);
+        }
+    });
+}
@@ -1,23 +1,135 @@
 import React from 'react';
+import { call } from './rpc';
+import { TaskMapping } from './task_mapping';
+import { CustomTasks } from './custom_tasks';
+import { HarvestProjects, excludedProjects } from './harvest_projects';
 
 export class Settings extends React.Component {
+    constructor(props) {
+        super(props);
+
+        this.save = this.save.bind(this);
+        this.testJira = this.testJira.bind(this);
+        this.loginJira = this.loginJira.bind(this);
+        this.loginHarvest = this.loginHarvest.bind(this);
+        this.state = { jiraStatus: '', harvestStatus: '', harvestAccounts: [] };
+    }
+
+    componentDidMount() {
+        call('harvest.accounts', {}, (accounts) => {
+            this.setState({ harvestAccounts: accounts || [] });
+        });
+    }
+
     submit(e) {
         e.preventDefault();
     }
 
+    jiraSettings() {
+        return {
+            url: document.getElementById('jiraUrl').value,
+            user: document.getElementById('jiraUser').value,
+            pass: document.getElementById('jiraPass').value,
+            auth: document.getElementById('jiraAuth').value,
+            clientId: document.getElementById('jiraClientId').value,
+            clientSecret: document.getElementById('jiraClientSecret').value
+        };
+    }
+
+    testJira() {
+        this.setState({ jiraStatus: 'Connecting...' });
+        call('jira.test', this.jiraSettings(), (user) => {
+            this.setState({ jiraStatus: 'Connected as ' + user.name + (user.email ? ' (' + user.email + ')' : '') });
+        });
+    }
+
+    loginJira() {
+        this.setState({ jiraStatus: 'Approve the login in the browser, then save' });
+        call('jira.login', this.jiraSettings());
+    }
+
+    harvestSettings() {
+        return {
+            user: document.getElementById('harvestUser').value,
+            pass: document.getElementById('harvestPass').value,
+            auth: document.getElementById('harvestAuth').value,
+            clientId: document.getElementById('harvestClientId').value,
+            clientSecret: document.getElementById('harvestClientSecret').value
+        };
+    }
+
+    loginHarvest() {
+        this.setState({ harvestStatus: 'Approve the login in the browser, then choose the account' });
+        call('harvest.login', this.harvestSettings());
+    }
+
     save() {
         var settings = {
-            jira: {
-                url: document.getElementById('jiraUrl').value,
-                user: document.getElementById('jiraUser').value,
-                pass: document.getElementById('jiraPass').value
-            },
-            harvest: {
-                user: document.getElementById('harvestUser').value,
-                pass: document.getElementById('harvestPass').value
+            jira: this.jiraSettings(),
+            harvest: this.harvestSettings(),
+            jiraQueries: this.parseQueries(document.getElementById('jiraQueries').value),
+            jiraWorklogs: document.getElementById('jiraWorklogs').value === 'true',
+            excludedProjects: excludedProjects(appData.data.settings.excludedProjects),
+            idleMinutes: parseInt(document.getElementById('idleMinutes').value, 10) || 0,
+            timerMode: document.getElementById('timerMode').value,
+            syncWeeks: parseInt(document.getElementById('syncWeeks').value, 10) || 0,
+            syncStrategy: document.getElementById('syncStrategy').value
+        }
+        call('settings.save', settings);
+    }
+
+    // Queries are entered one per line as "Name | JQL"
+    parseQueries(value) {
+        return value.split('\n').filter((line) => line.trim()).map((line) => {
+            const i = line.indexOf('|');
+            if (i < 0) {
+                return { name: line.trim(), jql: line.trim() };
             }
+            return { name: line.slice(0, i).trim(), jql: line.slice(i + 1).trim() };
+        });
+    }
+
+    formatQueries(queries) {
+        return (queries || []).map((q) => q.name + ' | ' + q.jql).join('\n');
+    }
+
+    input(options) {
+        if (options.type === 'select') {
+            return (
+                <select
+                    className="form-control form-control-sm"
+                    id={options.id}
+                    defaultValue={options.defaultValue}
+                    aria-describedby={options.id + 'Help'}
+                >
+                    {options.options.map((o) => <option key={o.value} value={o.value}>{o.label}</option>)}
+                </select>
+            );
         }
-        astilectron.sendMessage('settings|' + JSON.stringify(settings));
+
+        if (options.type === 'textarea') {
+            return (
+                <textarea
+                    className="form-control form-control-sm"
+                    id={options.id}
+                    rows="3"
+                    placeholder={options.placeholder}
+                    defaultValue={options.defaultValue}
+                    aria-describedby={options.id + 'Help'}
+                />
+            );
+        }
+
+        return (
+            <input
+                type={options.type}
+                className="form-control form-control-sm"
+                id={options.id}
+                placeholder={options.placeholder}
+                defaultValue={options.defaultValue}
+                aria-describedby={options.id + 'Help'}
+            />
+        );
     }
 
     description(options) {
@@ -28,7 +140,25 @@ export class Settings extends React.Component {
         const forms = [
             {
                 'group': 'Jira',
+                'buttons': [
+                    { 'label': 'Test connection', 'onClick': this.testJira },
+                    { 'label': 'Log in with OAuth', 'onClick': this.loginJira }
+                ],
+                'status': this.state.jiraStatus,
                 'forms': [
+                    {
+                        'label': 'Authentication',
+                        'type': 'select',
+                        'id': 'jiraAuth',
+                        'defaultValue': (appData.data.settings.jira && appData.data.settings.jira.auth) || 'basic',
+                        'options': [
+                            { 'value': 'basic', 'label': 'Username and password' },
+                            { 'value': 'token', 'label': 'Email and API token (cloud)' },
+                            { 'value': 'pat', 'label': 'Personal access token (server)' },
+                            { 'value': 'oauth', 'label': 'OAuth 2.0 (cloud)' },
+                            { 'value': 'oauth1', 'label': 'OAuth 1.0a application link (server)' }
+                        ]
+                    },
                     {
                         'label': 'URL',
                         'type': 'text',
@@ -41,26 +171,78 @@ export class Settings extends React.Component {
                         'type': 'text',
                         'id': 'jiraUser',
                         'placeholder': 'username',
-                        'defaultValue': (appData.data.settings.jira && appData.data.settings.jira.user)
+                        'defaultValue': (appData.data.settings.jira && appData.data.settings.jira.user),
+                        'description': 'The account email when using an API token'
                     },
                     {
                         'label': 'Password',
                         'type': 'password',
                         'id': 'jiraPass',
                         'placeholder': 'password',
-                        'defaultValue': (appData.data.settings.jira && appData.data.settings.jira.pass)
+                        'defaultValue': (appData.data.settings.jira && appData.data.settings.jira.pass),
+                        'description': 'Or the API token or personal access token'
+                    },
+                    {
+                        'label': 'OAuth client id',
+                        'type': 'text',
+                        'id': 'jiraClientId',
+                        'placeholder': 'client id',
+                        'defaultValue': (appData.data.settings.jira && appData.data.settings.jira.clientId),
+                        'description': 'Only for OAuth, create an app at https://developer.atlassian.com/console/myapps or use the consumer key of the application link'
+                    },
+                    {
+                        'label': 'OAuth client secret',
+                        'type': 'password',
+                        'id': 'jiraClientSecret',
+                        'placeholder': 'client secret',
+                        'defaultValue': (appData.data.settings.jira && appData.data.settings.jira.clientSecret),
+                        'description': 'Or the PEM private key of the application link'
+                    },
+                    {
+                        'label': 'Queries',
+                        'type': 'textarea',
+                        'id': 'jiraQueries',
+                        'placeholder': 'My sprint | assignee = currentUser() AND sprint in openSprints()',
+                        'defaultValue': this.formatQueries(appData.data.settings.jiraQueries),
+                        'description': 'One query per line as "Name | JQL", leave empty for all active issues'
+                    },
+                    {
+                        'label': 'Worklogs',
+                        'type': 'select',
+                        'id': 'jiraWorklogs',
+                        'defaultValue': appData.data.settings.jiraWorklogs ? 'true' : 'false',
+                        'options': [
+                            { 'value': 'false', 'label': 'Off' },
+                            { 'value': 'true', 'label': 'Log tracked time to issues' }
+                        ],
+                        'description': 'Adds a worklog per issue and day, updated when the time changes'
                     },
                 ]
             },
             {
                 'group': 'Harvest',
+                'buttons': [
+                    { 'label': 'Log in with Harvest', 'onClick': this.loginHarvest }
+                ],
+                'status': this.state.harvestStatus,
                 'forms': [
                     {
-                        'label': 'Account Id',
-                        'type': 'text',
+                        'label': 'Authentication',
+                        'type': 'select',
+                        'id': 'harvestAuth',
+                        'defaultValue': (appData.data.settings.harvest && appData.data.settings.harvest.auth) || 'token',
+                        'options': [
+                            { 'value': 'token', 'label': 'Personal access token' },
+                            { 'value': 'oauth', 'label': 'OAuth 2.0' }
+                        ]
+                    },
+                    {
+                        'label': 'Account',
+                        'type': this.state.harvestAccounts.length > 0 ? 'select' : 'text',
                         'id': 'harvestUser',
                         'placeholder': 'account_id',
                         'defaultValue': (appData.data.settings.harvest && appData.data.settings.harvest.user),
+                        'options': this.state.harvestAccounts.map((a) => ({ 'value': String(a.id), 'label': a.name })),
                         'description': 'A new application can be created at https://id.getharvest.com/developers'
                     },
                     {
@@ -68,7 +250,72 @@ export class Settings extends React.Component {
                         'type': 'password',
                         'id': 'harvestPass',
                         'placeholder': 'token',
-                        'defaultValue': (appData.data.settings.harvest && appData.data.settings.harvest.pass)
+                        'defaultValue': (appData.data.settings.harvest && appData.data.settings.harvest.pass),
+                        'description': 'Only for personal access tokens'
+                    },
+                    {
+                        'label': 'OAuth client id',
+                        'type': 'text',
+                        'id': 'harvestClientId',
+                        'placeholder': 'client id',
+                        'defaultValue': (appData.data.settings.harvest && appData.data.settings.harvest.clientId)
+                    },
+                    {
+                        'label': 'OAuth client secret',
+                        'type': 'password',
+                        'id': 'harvestClientSecret',
+                        'placeholder': 'client secret',
+                        'defaultValue': (appData.data.settings.harvest && appData.data.settings.harvest.clientSecret)
+                    },
+                    {
+                        'label': 'Sync weeks',
+                        'type': 'number',
+                        'id': 'syncWeeks',
+                        'placeholder': '5',
+                        'defaultValue': appData.data.settings.syncWeeks,
+                        'description': 'How many weeks of time to sync with harvest, at most 12'
+                    },
+                    {
+                        'label': 'Conflicts',
+                        'type': 'select',
+                        'id': 'syncStrategy',
+                        'defaultValue': appData.data.settings.syncStrategy || 'local',
+                        'options': [
+                            { 'value': 'local', 'label': 'Local time wins' },
+                            { 'value': 'remote', 'label': 'Harvest wins' },
+                            { 'value': 'max', 'label': 'Keep the most hours' },
+                            { 'value': 'manual', 'label': 'Ask me' }
+                        ],
+                        'description': 'Used when the hours of a day changed both locally and in harvest'
+                    }
+                ]
+            },
+            {
+                'group': 'Timers',
+                'forms': [
+                    {
+                        'label': 'Mode',
+                        'type': 'select',
+                        'id': 'timerMode',
+                        'defaultValue': appData.data.settings.timerMode || 'single',
+                        'options': [
+                            { 'value': 'single', 'label': 'One timer at a time' },
+                            { 'value': 'parallel', 'label': 'Timers run in parallel' }
+                        ],
+                        'description': 'Starting a timer stops the running one unless timers run in parallel'
+                    }
+                ]
+            },
+            {
+                'group': 'Idle',
+                'forms': [
+                    {
+                        'label': 'Idle minutes',
+                        'type': 'number',
+                        'id': 'idleMinutes',
+                        'placeholder': '0',
+                        'defaultValue': appData.data.settings.idleMinutes,
+                        'description': 'Ask what to do with time after being idle this long, 0 disables'
                     }
                 ]
             }
@@ -85,23 +332,28 @@ export class Settings extends React.Component {
                                     return (
                                         <div key={j} className="form-group">
                                             <label htmlFor={options.id}>{options.label}</label>
-                                            <input
-                                                type={options.type}
-                                                className="form-control form-control-sm"
-                                                id={options.id}
-                                                placeholder={options.placeholder}
-                                                defaultValue={options.defaultValue}
-                                                aria-describedby={options.id + 'Help'}
-                                            />
+                                            {this.input(options)}
                                             {options.description && this.description(options)}
                                         </div>
                                     );
                                 })}
+                                {group.buttons && group.buttons.map((button, j) => {
+                                    return (
+                                        <button key={j} type="button" className="btn btn-secondary btn-sm mr-1" onClick={button.onClick}>
+                                            {button.label}
+                                        </button>
+                                    );
+                                })}
+                                {group.status && <small className="form-text text-muted">{group.status}</small>}
                                 <br />
                             </div>
                         );
                     })}
 
+                    <HarvestProjects />
+                    <TaskMapping />
+                    <CustomTasks />
+
                     <button id="save" className="btn btn-primary btn-block" onClick={this.save}>Save</button>
                 </form>
             </div>
@@ -0,0 +1,125 @@
+import React from 'react';
+import { call } from './rpc';
+
+// TaskMapping picks the harvest task time is logged to for each project, with
+// optional rules for issue types or labels.
+export class TaskMapping extends React.Component {
+    constructor(props) {
+        super(props);
+
+        this.state = {
+            loaded: false,
+            projects: [],
+            mapping: { projects: {}, rules: [] },
+        };
+
+        this.save = this.save.bind(this);
+        this.addRule = this.addRule.bind(this);
+    }
+
+    componentDidMount() {
+        call('taskmap.get', null, (data) => {
+            this.setState({
+                loaded: true,
+                projects: data.projects,
+                mapping: {
+                    projects: data.mapping.projects || {},
+                    rules: data.mapping.rules || [],
+                },
+            });
+        });
+    }
+
+    setProjectTask(projectId, taskId) {
+        const mapping = this.state.mapping;
+        mapping.projects[projectId] = parseInt(taskId, 10);
+        this.setState({ mapping: mapping });
+    }
+
+    setRule(i, field, value) {
+        const mapping = this.state.mapping;
+        mapping.rules[i][field] = (field === 'projectId' || field === 'taskId') ? parseInt(value, 10) : value;
+        this.setState({ mapping: mapping });
+    }
+
+    addRule() {
+        if (!this.state.projects.length) {
+            return;
+        }
+
+        const project = this.state.projects[0];
+        const mapping = this.state.mapping;
+        mapping.rules.push({
+            projectId: project.id,
+            issueType: '',
+            label: '',
+            taskId: project.tasks.length ? project.tasks[0].id : 0,
+        });
+        this.setState({ mapping: mapping });
+    }
+
+    removeRule(i) {
+        const mapping = this.state.mapping;
+        mapping.rules.splice(i, 1);
+        this.setState({ mapping: mapping });
+    }
+
+    save() {
+        call('taskmap.save', this.state.mapping);
+    }
+
+    tasks(projectId) {
+        const project = this.state.projects.find((p) => p.id === projectId);
+        return project ? project.tasks || [] : [];
+    }
+
+    render() {
+        if (!this.state.loaded || !this.state.projects.length) {
+            return <></>;
+        }
+
+        return (
+            <div>
+                <h5>Harvest Tasks</h5>
+                {this.state.projects.map((project) => {
+                    return (
+                        <div key={project.id} className="form-group">
+                            <label>{project.code}: {project.name}</label>
+                            <select
+                                className="form-control form-control-sm"
+                                value={this.state.mapping.projects[project.id] || ''}
+                                onChange={(e) => this.setProjectTask(project.id, e.target.value)}
+                            >
+                                <option value="">Coding</option>
+                                {(project.tasks || []).map((task) => <option key={task.id} value={task.id}>{task.name}</option>)}
+                            </select>
+                        </div>
+                    );
+                })}
+
+                <h6>Rules</h6>
+                {this.state.mapping.rules.map((rule, i) => {
+                    return (
+                        <div key={i} className="form-inline task-rule">
+                            <select className="form-control form-control-sm" value={rule.projectId} onChange={(e) => this.setRule(i, 'projectId', e.target.value)}>
+                                {this.state.projects.map((p) => <option key={p.id} value={p.id}>{p.code}</option>)}
+                            </select>
+                            <input className="form-control form-control-sm" placeholder="issue type" value={rule.issueType} onChange={(e) => this.setRule(i, 'issueType', e.target.value)} />
+                            <input className="form-control form-control-sm" placeholder="label" value={rule.label} onChange={(e) => this.setRule(i, 'label', e.target.value)} />
+                            <select className="form-control form-control-sm" value={rule.taskId} onChange={(e) => this.setRule(i, 'taskId', e.target.value)}>
+                                {this.tasks(rule.projectId).map((t) => <option key={t.id} value={t.id}>{t.name}</option>)}
+                            </select>
+                            <img onClick={() => this.removeRule(i)} src="/img/icons/close.png" height="16px" />
+                        </div>
+                    );
+                })}
+
+                <div className="btn-group btn-group-sm task-mapping-buttons" role="group">
+                    <button type="button" className="btn btn-sm btn-dark" onClick={this.addRule}>Add rule</button>
+                    <button type="button" className="btn btn-sm btn-dark" onClick={this.save}>Save tasks</button>
+                </div>
+                <br />
+            </div>
+        );
+    }
+}
@@ -1,4 +1,5 @@
 import React from 'react';
+import { call } from './rpc';
 
 export class Timer extends React.Component {
     constructor(props) {
@@ -6,36 +7,56 @@ export class Timer extends React.Component {
 
         this.stopTimer = this.stopTimer.bind(this);
         this.startTimer = this.startTimer.bind(this);
+        this.pauseTimer = this.pauseTimer.bind(this);
         this.openLink = this.openLink.bind(this);
+        this.saveNotes = this.saveNotes.bind(this);
     }
 
     stopTimer() {
-        astilectron.sendMessage("stop|" + this.props.timer.key);
+        call("timer.stop", { key: this.props.timer.key });
     }
 
     startTimer() {
-        astilectron.sendMessage("start|" + this.props.timer.key);
+        call("timer.start", { key: this.props.timer.key });
+    }
+
+    pauseTimer() {
+        call("timer.pause", { key: this.props.timer.key });
+    }
+
+    saveNotes(e) {
+        if (e.target.value === (this.props.timer.notes || "")) {
+            return;
+        }
+        call("timer.notes", { key: this.props.timer.key, notes: e.target.value });
     }
 
     openLink() {
-        astilectron.sendMessage("open|" + this.props.timer.key);
+        call("timer.open", { key: this.props.timer.key });
     }
 
     render() {
         const timer = this.props.timer;
 
         let iconSrc = '/img/icons/jira.png';
-        if (timer.harvest != undefined) {
+        if (timer.project != undefined) {
             iconSrc = '/img/icons/harvest.png';
         }
-        const icon = <img src={iconSrc} height="20px" />;
+        let icon = <img src={iconSrc} height="20px" />;
+
+        // Custom tasks are marked with their color instead
+        if (timer.custom != undefined) {
+            icon = <span className="custom-task-color" style={{ backgroundColor: timer.custom.color || '#6c757d' }} />;
+        }
 
 
         let description = "";
-        if (timer.jira != undefined) {
-            description = timer.jira.fields.summary;
-        } else if (timer.harvest != undefined) {
-            description = timer.harvest.project.name;
+        if (timer.custom != undefined) {
+            description = timer.custom.title;
+        } else if (timer.issue != undefined) {
+            description = timer.issue.summary;
+        } else if (timer.project != undefined) {
+            description = timer.project.name;
         }
 
         const playImg = '/img/icons/play.png';
@@ -47,17 +68,45 @@ export class Timer extends React.Component {
                 className="btn btn-dark btn-sm timer-btn"
             >
                 {timer.running ? <img src={stopImg} height="20px" /> : <img src={playImg} height="20px" />}
-                {timer.running && timer.runtime}
+                {(timer.running || timer.paused) && timer.runtime}
             </button >
         )
 
+        // Running timers can be paused and paused timers stopped for good
+        let secondary = null;
+        if (timer.running) {
+            secondary = (
+                <button type="button" onClick={this.pauseTimer} className="btn btn-dark btn-sm timer-btn">
+                    <span className="timer-pause">&#10074;&#10074;</span>
+                </button>
+            );
+        } else if (timer.paused) {
+            secondary = (
+                <button type="button" onClick={this.stopTimer} className="btn btn-dark btn-sm timer-btn">
+                    <img src={stopImg} height="20px" />
+                </button>
+            );
+        }
+
         return (
             <div className="d-flex flex-row align-middle task-timer align-items-center">
                 <div className="p-1">{icon}</div>
                 <div className="col text-truncate">
                     <a href="#" onClick={this.openLink} className="jira-link">{timer.key}: {description}</a>
+                    {timer.issue && timer.issue.query && <span className="badge badge-secondary timer-query">{timer.issue.query}</span>}
+                    {(timer.running || timer.paused) &&
+                        <input
+                            key={timer.key + (timer.notes || "")}
+                            type="text"
+                            placeholder="notes"
+                            className="form-control form-control-sm timer-notes"
+                            defaultValue={timer.notes}
+                            onBlur={this.saveNotes}
+                        />
+                    }
                 </div>
                 <div className="p-1">
+                    {secondary}
                     {button}
                 </div >
             </div>
@@ -1,5 +1,8 @@
 import React from 'react';
 import Moment from 'react-moment';
+import moment from 'moment';
+import { call } from './rpc';
+import { exportTimesheet } from './export';
 
 export class TimeSheet extends React.Component {
     constructor(props) {
@@ -8,7 +11,11 @@ export class TimeSheet extends React.Component {
         this.state = {
             firstRender: true,
             activeView: 'day',
+            groupBy: 'key',
+            rangeStart: moment().startOf('month').format('YYYY-MM-DD'),
+            rangeEnd: moment().format('YYYY-MM-DD'),
             currentTimesheet: "",
+            timeline: [],
         };
 
         this.activateTab = this.activateTab.bind(this);
@@ -16,44 +23,132 @@ export class TimeSheet extends React.Component {
         this.dateBack = this.dateBack.bind(this);
         this.dateForward = this.dateForward.bind(this);
         this.day = this.day.bind(this);
-        this.week = this.week.bind(this);
+        this.period = this.period.bind(this);
+        this.setGroup = this.setGroup.bind(this);
+        this.setRange = this.setRange.bind(this);
         this.copy = this.copy.bind(this);
+        this.export = this.export.bind(this);
+        this.editTime = this.editTime.bind(this);
+        this.editNotes = this.editNotes.bind(this);
+        this.deleteTime = this.deleteTime.bind(this);
+        this.addTime = this.addTime.bind(this);
     }
 
-    sendToBackend(tab, message) {
-        if (message) {
-            message = tab + message;
-        } else {
-            message = tab;
-        }
+    sendToBackend(tab, move, groupBy = this.state.groupBy) {
+        const request = {
+            view: tab,
+            start: this.state.currentTimesheet.timeStart,
+            move: move,
+            groupBy: groupBy,
+        };
 
-        astilectron.sendMessage(message, function (response) {
-            if (response === undefined) {
-                return;
+        // Ranges keep their length when moving back and forth
+        if (tab === 'range') {
+            if (move === '=') {
+                request.start = moment(this.state.rangeStart).format();
+                request.end = moment(this.state.rangeEnd).format();
+            } else if (this.state.activeView === 'range') {
+                request.end = this.state.currentTimesheet.timeEnd;
             }
+        }
 
+        call('timesheet', request, function (response) {
             this.setState({
                 firstRender: false,
                 activeView: tab,
+                groupBy: groupBy,
                 currentTimesheet: response
             });
+
+            if (tab === 'day' && groupBy === 'key') {
+                const day = moment(response.timeStart).format('YYYY-MM-DD');
+                call('timesheet.timeline', { day: day }, (timeline) => this.setState({ timeline: timeline }));
+            }
         }.bind(this));
     }
 
     activateTab(tab) {
-        this.sendToBackend(tab, '|' + this.state.currentTimesheet.timeStart + '|=');
+        this.sendToBackend(tab, '=');
     }
 
     dateBack(tab) {
-        this.sendToBackend(tab, '|' + this.state.currentTimesheet.timeStart + '|-');
+        this.sendToBackend(tab, '-');
     }
 
     dateForward(tab) {
-        this.sendToBackend(tab, '|' + this.state.currentTimesheet.timeStart + '|+');
+        this.sendToBackend(tab, '+');
+    }
+
+    setGroup(e) {
+        this.sendToBackend(this.state.activeView, '.', e.target.value);
+    }
+
+    setRange(field, value) {
+        if (!value) {
+            return;
+        }
+        this.setState({ [field]: value }, () => this.sendToBackend('range', '='));
     }
 
     copy(tab) {
-        this.sendToBackend(tab, '|' + this.state.currentTimesheet.timeStart + '|copy');
+        call('timesheet.copy', { view: tab, start: this.state.currentTimesheet.timeStart });
+    }
+
+    export(e) {
+        const format = e.target.value;
+        e.target.value = '';
+        if (!format || !this.state.currentTimesheet) {
+            return;
+        }
+
+        exportTimesheet({
+            view: this.state.activeView,
+            start: this.state.currentTimesheet.timeStart,
+            end: this.state.currentTimesheet.timeEnd,
+            move: '.',
+            groupBy: this.state.groupBy,
+        }, format);
+    }
+
+    currentDay() {
+        return moment(this.state.currentTimesheet.timeStart).format('YYYY-MM-DD');
+    }
+
+    reload() {
+        this.sendToBackend(this.state.activeView, '.');
+    }
+
+    editTime(key, value) {
+        const hours = parseFloat(value);
+        if (isNaN(hours)) {
+            return;
+        }
+
+        call('timer.set', { key: key, day: this.currentDay(), hours: hours }, () => this.reload());
+    }
+
+    editNotes(task, value) {
+        if (value === (task.notes || "")) {
+            return;
+        }
+
+        call('timer.notes', { key: task.key, day: this.currentDay(), notes: value }, () => this.reload());
+    }
+
+    deleteTime(key) {
+        call('timer.delete', { key: key, day: this.currentDay() }, () => this.reload());
+    }
+
+    addTime(e) {
+        e.preventDefault();
+
+        const key = document.getElementById('addTimeKey').value;
+        const hours = parseFloat(document.getElementById('addTimeHours').value);
+        if (!key || isNaN(hours)) {
+            return;
+        }
+
+        call('timer.add', { key: key, day: this.currentDay(), hours: hours }, () => this.reload());
     }
 
     datePicker(tab) {
@@ -67,6 +162,33 @@ export class TimeSheet extends React.Component {
         let content = <Moment format="MMM Do" date={timeStart} />;
         if (tab === 'week') {
             content = <div><Moment format="MMM Do" date ={timeStart} /> - <Moment format="MMM Do" date={timeEnd} /></div>;
+        } else if (tab === 'month') {
+            content = <Moment format="MMMM YYYY" date={timeStart} />;
+        } else if (tab === 'range') {
+            return (
+                <div className="btn-group btn-group-sm btn-datepicker" role="group">
+                    <button type="button" className="btn btn-sm btn-dark" onClick={() => this.dateBack(tab)}>
+                        <img src="/img/icons/left.png" height="20px" />
+                    </button>
+                    <input
+                        type="date"
+                        className="form-control form-control-sm range-input"
+                        key={'start' + timeStart}
+                        defaultValue={moment(timeStart).format('YYYY-MM-DD')}
+                        onChange={(e) => this.setRange('rangeStart', e.target.value)}
+                    />
+                    <input
+                        type="date"
+                        className="form-control form-control-sm range-input"
+                        key={'end' + timeEnd}
+                        defaultValue={moment(timeEnd).format('YYYY-MM-DD')}
+                        onChange={(e) => this.setRange('rangeEnd', e.target.value)}
+                    />
+                    <button type="button" className="btn btn-sm btn-dark" onClick={() => this.dateForward(tab)}>
+                        <img src="/img/icons/right.png" height="20px" />
+                    </button>
+                </div>
+            );
         }
 
         return (
@@ -94,45 +216,103 @@ export class TimeSheet extends React.Component {
                     <tr>
                         <td>Jira</td>
                         <td align="right">Hours</td>
+                        <td></td>
                     </tr>
                 </thead>
                 <tbody>
                     {timesheet.tasks.map((jira, i) => {
                         return (
-                            <tr key={i}>
-                                <td>{jira.key}</td>
-                                <td align="right">{jira.totalTime}</td>
+                            <tr key={jira.key + timesheet.timeStart}>
+                                <td>
+                                    {jira.key}
+                                    <input
+                                        type="text"
+                                        placeholder="notes"
+                                        className="form-control form-control-sm timer-notes"
+                                        defaultValue={jira.notes}
+                                        onBlur={(e) => this.editNotes(jira, e.target.value)}
+                                    />
+                                </td>
+                                <td align="right">
+                                    <input
+                                        type="number"
+                                        step="0.25"
+                                        min="0"
+                                        className="form-control form-control-sm time-input"
+                                        defaultValue={jira.totalTime}
+                                        onBlur={(e) => this.editTime(jira.key, e.target.value)}
+                                    />
+                                </td>
+                                <td align="right">
+                                    <img onClick={() => this.deleteTime(jira.key)} src="/img/icons/close.png" height="16px" />
+                                </td>
                             </tr>
                         );
                     })}
-                    <tr><td colSpan="2">&nbsp;</td></tr>
+                    <tr>
+                        <td><input id="addTimeKey" type="text" placeholder="key" className="form-control form-control-sm" /></td>
+                        <td align="right">
+                            <input id="addTimeHours" type="number" step="0.25" min="0" placeholder="hours" className="form-control form-control-sm time-input" />
+                        </td>
+                        <td align="right"><button type="button" className="btn btn-sm btn-dark" onClick={this.addTime}>Add</button></td>
+                    </tr>
+                    <tr><td colSpan="3">&nbsp;</td></tr>
                     <tr  className="total-row">
                         <td>Total</td>
                         <td align="right">{timesheet.total}</td>
+                        <td></td>
                     </tr>
                 </tbody>
             </table>
         );
     }
 
-    week() {
+    timeline() {
+        if (!this.state.timeline.length) {
+            return <></>;
+        }
+
+        return (
+            <table className="time-table timeline">
+                <tbody>
+                    {this.state.timeline.map((entry, i) => {
+                        return (
+                            <tr key={i} className={entry.overlaps ? 'timeline-overlap' : ''}>
+                                <td>
+                                    <Moment format="HH:mm" date={entry.start} /> - <Moment format="HH:mm" date={entry.end} />
+                                </td>
+                                <td>{entry.key}</td>
+                                <td align="right">{entry.source}</td>
+                            </tr>
+                        );
+                    })}
+                </tbody>
+            </table>
+        );
+    }
+
+    period() {
         const timesheet = this.state.currentTimesheet
         if (!timesheet) {
             return <></>;
         }
 
+        const dayFormat = this.state.activeView === 'week' ? 'ddd' : 'D';
+        const groupName = {
+            key: 'Key',
+            project: 'Project',
+            client: 'Client',
+            epic: 'Epic',
+        }[timesheet.groupBy];
+
         return (
             <table className="time-table ">
                 <thead>
                     <tr>
-                        <td>Jira</td>
-                        <td align="right">Mon</td>
-                        <td align="right">Tue</td>
-                        <td align="right">Wed</td>
-                        <td align="right">Thu</td>
-                        <td align="right">Fri</td>
-                        <td align="right">Sat</td>
-                        <td align="right">Sun</td>
+                        <td>{groupName}</td>
+                        {timesheet.days.map((day, i) => {
+                            return <td key={i} align="right"><Moment format={dayFormat} date={day} /></td>;
+                        })}
                         <td align="right">Total</td>
                     </tr>
                 </thead>
@@ -140,7 +320,7 @@ export class TimeSheet extends React.Component {
                     {timesheet.tasks.map((jira, i) => {
                         return (
                             <tr key={i}>
-                                <td>{jira.key}</td>
+                                <td title={jira.keys ? jira.keys.join(', ') : jira.notes}>{jira.key}</td>
                                 {jira.durations.map((duration, j) => {
                                     return <td key={j} align="right">{duration}</td>
                                 })}
@@ -148,7 +328,7 @@ export class TimeSheet extends React.Component {
                             </tr>
                         );
                     })}
-                    <tr><td colSpan="9">&nbsp;</td></tr>
+                    <tr><td colSpan={timesheet.days.length + 2}>&nbsp;</td></tr>
                     <tr className="total-row">
                         <td>Total</td>
                         {timesheet.daysTotal.map((t, i) => {
@@ -165,8 +345,13 @@ export class TimeSheet extends React.Component {
         const tabs = [
             'day',
             'week',
+            'month',
+            'range',
         ];
 
+        // Only the time of single keys on a day can be edited
+        const editable = this.state.activeView === 'day' && this.state.groupBy === 'key';
+
         return (
             <div id="timesheet" className="container-fluid">
                 <div className="row">
@@ -189,6 +374,23 @@ export class TimeSheet extends React.Component {
                     </div>
                     <div className="p-2">{this.datePicker(this.state.activeView)}</div>
                     <div className="col">&nbsp;</div>
+                    <div className="p-2">
+                        <select className="form-control form-control-sm" value={this.state.groupBy} onChange={this.setGroup}>
+                            <option value="key">By key</option>
+                            <option value="project">By project</option>
+                            <option value="client">By client</option>
+                            <option value="epic">By epic</option>
+                        </select>
+                    </div>
+                    <div className="p-2">
+                        <select className="form-control form-control-sm" defaultValue="" onChange={this.export}>
+                            <option value="" disabled>Export</option>
+                            <option value="csv">CSV</option>
+                            <option value="json">JSON</option>
+                            <option value="html">HTML report</option>
+                            <option value="pdf">PDF report</option>
+                        </select>
+                    </div>
                     <div className="p-2">
                         <img
                             onClick={() => this.copy(this.state.activeView)}
@@ -200,7 +402,8 @@ export class TimeSheet extends React.Component {
                 </div>
 
                 {this.state.firstRender && this.activateTab('day')}
-                {this[this.state.activeView](this.state.currentTimesheet)}
+                {editable ? this.day() : this.period()}
+                {editable && this.timeline()}
             </div>
         );
     }
@@ -1,20 +1,25 @@
 import React from 'react';
+import { call } from './rpc';
 
 export class Toolbar extends React.Component {
     refresh() {
-        astilectron.sendMessage("refresh");
+        call("refresh");
     }
 
     settings() {
-        astilectron.sendMessage("settings");
+        call("view", { name: "settings" });
     }
 
     timesheet() {
-        astilectron.sendMessage("timesheet");
+        call("view", { name: "timesheet" });
+    }
+
+    backfill() {
+        call("view", { name: "backfill" });
     }
 
     harvest() {
-        astilectron.sendMessage("harvest");
+        call("harvest.open");
     }
 
     render() {
@@ -23,6 +28,13 @@ export class Toolbar extends React.Component {
                 <div className="p-2"><img onClick={this.harvest} src="/img/icons/harvest.png" height="20px" /></div>
                 <div className="p-2"><img onClick={this.refresh} src="/img/icons/refresh.png" height="20px" /></div>
                 <div className="col">&nbsp;</div>
+                {(appData.data.pending > 0 || appData.data.view === 'backfill') && (
+                    <div className="p-2">
+                        <span onClick={this.backfill} className="badge badge-warning backfill-badge">
+                            {appData.data.view === 'backfill' ? 'close' : appData.data.pending + ' to sync'}
+                        </span>
+                    </div>
+                )}
                 <div className="p-2">
                     <img
                         onClick={this.timesheet}